@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/peer"
+)
+
+// acl decides which clients may query the plugin. A client matching a denied
+// network is refused; otherwise, if allowed networks are given, the client
+// must match one of them.
+type acl struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// newACL parses comma separated lists of allowed and denied CIDRs. It returns
+// nil if both lists are empty, which allows all clients.
+func newACL(allow string, deny string) (*acl, error) {
+	a := &acl{}
+	var err error
+	if a.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	if len(a.allow) == 0 && len(a.deny) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0)
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// permits reports whether the client may query. A nil acl permits everyone.
+func (a *acl) permits(client net.IP) bool {
+	if a == nil {
+		return true
+	}
+	if client == nil {
+		// unknown clients only get through when nothing is explicitly allowed
+		return len(a.allow) == 0
+	}
+	for _, network := range a.deny {
+		if network.Contains(client) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, network := range a.allow {
+		if network.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the gRPC peer, or nil if it is unknown.
+func clientIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+	switch addr := p.Addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// requestClient returns the address of the client a query is asked for: the
+// address of its EDNS0 Client Subnet option (RFC 7871), which forwarders add
+// for the client they forward for, and the gRPC peer's otherwise. A subnet
+// with a source prefix length of 0 asks for the client not to be used.
+func requestClient(ctx context.Context, request *dns.Msg) net.IP {
+	if opt := request.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok && subnet.SourceNetmask > 0 && subnet.Address != nil {
+				return subnet.Address
+			}
+		}
+	}
+	return clientIP(ctx)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	dnsapi "github.com/istio-ecosystem/istio-coredns-plugin/api"
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/peer"
+)
+
+func TestACLPermits(t *testing.T) {
+	tests := []struct {
+		name   string
+		allow  string
+		deny   string
+		client net.IP
+		want   bool
+	}{
+		{name: "no lists", client: net.ParseIP("192.0.2.1"), want: true},
+		{name: "allowed", allow: "10.0.0.0/8, 2001:db8::/32", client: net.ParseIP("10.1.2.3"), want: true},
+		{name: "allowed v6", allow: "10.0.0.0/8, 2001:db8::/32", client: net.ParseIP("2001:db8::1"), want: true},
+		{name: "not allowed", allow: "10.0.0.0/8", client: net.ParseIP("192.0.2.1")},
+		{name: "denied", deny: "10.0.0.0/8", client: net.ParseIP("10.1.2.3")},
+		{name: "not denied", deny: "10.0.0.0/8", client: net.ParseIP("192.0.2.1"), want: true},
+		{name: "deny wins", allow: "10.0.0.0/8", deny: "10.1.0.0/16", client: net.ParseIP("10.1.2.3")},
+		{name: "unknown client", deny: "10.0.0.0/8", want: true},
+		{name: "unknown client not allowed", allow: "10.0.0.0/8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newACL(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := a.permits(tt.client); got != tt.want {
+				t.Errorf("permits(%v) = %v, want %v", tt.client, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewACLInvalid(t *testing.T) {
+	for _, list := range []string{"10.0.0.1", "10.0.0.0/33", "foo"} {
+		if _, err := newACL(list, ""); err == nil {
+			t.Errorf("newACL(%q) succeeded, want an error", list)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want net.IP
+	}{
+		{name: "no peer", ctx: context.Background()},
+		{name: "tcp", ctx: peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}}), want: net.ParseIP("10.0.0.1")},
+		{name: "udp", ctx: peer.NewContext(context.Background(), &peer.Peer{Addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}}), want: net.ParseIP("2001:db8::1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientIP(tt.ctx); !got.Equal(tt.want) {
+				t.Errorf("clientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// withSubnet adds an EDNS0 Client Subnet option to the request.
+func withSubnet(request *dns.Msg, address string, netmask uint8) *dns.Msg {
+	ip := net.ParseIP(address)
+	family := uint16(1)
+	if ip.To4() == nil {
+		family = 2
+	}
+	request.SetEdns0(1232, false)
+	opt := request.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: family, SourceNetmask: netmask, Address: ip})
+	return request
+}
+
+func TestRequestClient(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}})
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		request *dns.Msg
+		want    net.IP
+	}{
+		{name: "peer", ctx: ctx, request: new(dns.Msg), want: net.ParseIP("10.0.0.1")},
+		{name: "no peer", ctx: context.Background(), request: new(dns.Msg)},
+		{name: "subnet", ctx: ctx, request: withSubnet(new(dns.Msg), "192.0.2.0", 24), want: net.ParseIP("192.0.2.0")},
+		{name: "subnet v6", ctx: ctx, request: withSubnet(new(dns.Msg), "2001:db8::", 56), want: net.ParseIP("2001:db8::")},
+		{name: "subnet without peer", ctx: context.Background(), request: withSubnet(new(dns.Msg), "192.0.2.0", 24), want: net.ParseIP("192.0.2.0")},
+		{name: "subnet not to be used", ctx: ctx, request: withSubnet(new(dns.Msg), "0.0.0.0", 0), want: net.ParseIP("10.0.0.1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the option is read from the request as unpacked
+			in, err := tt.request.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			request := new(dns.Msg)
+			if err := request.Unpack(in); err != nil {
+				t.Fatal(err)
+			}
+			if got := requestClient(tt.ctx, request); !got.Equal(tt.want) {
+				t.Errorf("requestClient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestACLQueries(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.1.0.1")}}, nil)
+	var err error
+	if h.acl, err = newACL("192.0.2.0/24", ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		peer    string
+		request *dns.Msg
+		rcode   int
+	}{
+		{name: "allowed peer", peer: "192.0.2.1", request: new(dns.Msg)},
+		{name: "refused peer", peer: "10.0.0.1", request: new(dns.Msg), rcode: dns.RcodeRefused},
+		{name: "allowed client of refused peer", peer: "10.0.0.1", request: withSubnet(new(dns.Msg), "192.0.2.0", 24)},
+		{name: "refused client of allowed peer", peer: "192.0.2.1", request: withSubnet(new(dns.Msg), "198.51.100.0", 24), rcode: dns.RcodeRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := tt.request.SetQuestion("foo.com.", dns.TypeA).Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 53}})
+			out, err := h.Query(ctx, &dnsapi.DnsPacket{Msg: in})
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := new(dns.Msg)
+			if err := response.Unpack(out.Msg); err != nil {
+				t.Fatal(err)
+			}
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+		})
+	}
+}
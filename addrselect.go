@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Destination address ordering following RFC 6724 section 6. Only the rules
+// that need nothing but the client (source) address are applied: matching
+// scope (2), matching label (5), higher precedence (6), smaller scope (8)
+// and longest matching prefix (9). Ties keep the configured order (10).
+
+type policyEntry struct {
+	prefix     *net.IPNet
+	precedence int
+	label      int
+}
+
+// policyTable is the default policy table of RFC 6724 section 2.1, longest
+// prefixes first.
+var policyTable = []policyEntry{
+	{mustCIDR("::1/128"), 50, 0},
+	{mustCIDR("::ffff:0:0/96"), 35, 4},
+	{mustCIDR("::/96"), 1, 3},
+	{mustCIDR("2001::/32"), 5, 5},
+	{mustCIDR("2002::/16"), 30, 2},
+	{mustCIDR("3ffe::/16"), 1, 12},
+	{mustCIDR("fec0::/10"), 1, 11},
+	{mustCIDR("fc00::/7"), 3, 13},
+	{mustCIDR("::/0"), 40, 1},
+}
+
+func mustCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// classify returns the precedence and label of ip in the policy table. IPv4
+// addresses are looked up as IPv4-mapped IPv6 addresses.
+func classify(ip net.IP) (precedence int, label int) {
+	ip16 := ip.To16()
+	for _, p := range policyTable {
+		if p.prefix.Contains(ip16) {
+			return p.precedence, p.label
+		}
+	}
+	return 0, 0
+}
+
+// Address scopes of RFC 4291 section 2.7, used for IPv4 as described in
+// RFC 6724 section 3.2.
+const (
+	scopeLinkLocal = 0x2
+	scopeSiteLocal = 0x5
+	scopeGlobal    = 0xe
+)
+
+func scope(ip net.IP) int {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return scopeLinkLocal
+	}
+	if ip.To4() == nil && len(ip) == net.IPv6len && ip[0] == 0xfe && ip[1]&0xc0 == 0xc0 {
+		return scopeSiteLocal
+	}
+	return scopeGlobal
+}
+
+// commonPrefixLen returns the number of leading bits a and b share, or 0 if
+// they are of different families.
+func commonPrefixLen(a net.IP, b net.IP) int {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil || b4 != nil {
+		if a4 == nil || b4 == nil {
+			return 0
+		}
+		a, b = a4, b4
+	} else {
+		a, b = a.To16(), b.To16()
+	}
+	n := 0
+	for i := range a {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return n
+}
+
+// sortRFC6724 returns a copy of ips ordered for a client with the given
+// source address.
+func sortRFC6724(ips []net.IP, client net.IP) []net.IP {
+	sorted := make([]net.IP, len(ips))
+	copy(sorted, ips)
+	srcScope := scope(client)
+	_, srcLabel := classify(client)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		// Rule 2: prefer matching scope
+		if as, bs := scope(a) == srcScope, scope(b) == srcScope; as != bs {
+			return as
+		}
+		aPrec, aLabel := classify(a)
+		bPrec, bLabel := classify(b)
+		// Rule 5: prefer matching label
+		if al, bl := aLabel == srcLabel, bLabel == srcLabel; al != bl {
+			return al
+		}
+		// Rule 6: prefer higher precedence
+		if aPrec != bPrec {
+			return aPrec > bPrec
+		}
+		// Rule 8: prefer smaller scope
+		if as, bs := scope(a), scope(b); as != bs {
+			return as < bs
+		}
+		// Rule 9: use longest matching prefix
+		return commonPrefixLen(a, client) > commonPrefixLen(b, client)
+	})
+	return sorted
+}
+
+// familyOrder controls how IPv4 and IPv6 addresses are arranged in answers
+// carrying both.
+type familyOrder int
+
+const (
+	// familyAsIs keeps the order of the other ordering rules
+	familyAsIs familyOrder = iota
+	// familyIPv4First lists IPv4 before IPv6 addresses
+	familyIPv4First
+	// familyIPv6First lists IPv6 before IPv4 addresses
+	familyIPv6First
+	// familyInterleave alternates between the families, starting with the
+	// family of the first address
+	familyInterleave
+)
+
+func parseFamilyOrder(order string) (familyOrder, error) {
+	switch order {
+	case "", "none":
+		return familyAsIs, nil
+	case "ipv4":
+		return familyIPv4First, nil
+	case "ipv6":
+		return familyIPv6First, nil
+	case "interleave":
+		return familyInterleave, nil
+	}
+	return familyAsIs, fmt.Errorf("unknown order %q, expected none, ipv4, ipv6 or interleave", order)
+}
+
+// orderFamilies arranges ips by address family, keeping the relative order
+// of the addresses of each family.
+func orderFamilies(ips []net.IP, order familyOrder) []net.IP {
+	if order == familyAsIs || len(ips) < 2 {
+		return ips
+	}
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	switch order {
+	case familyIPv4First:
+		ordered = append(append(ordered, v4...), v6...)
+	case familyIPv6First:
+		ordered = append(append(ordered, v6...), v4...)
+	case familyInterleave:
+		first, second := v4, v6
+		if ips[0].To4() == nil {
+			first, second = v6, v4
+		}
+		for i := 0; i < len(first) || i < len(second); i++ {
+			if i < len(first) {
+				ordered = append(ordered, first[i])
+			}
+			if i < len(second) {
+				ordered = append(ordered, second[i])
+			}
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	dnsapi "github.com/istio-ecosystem/istio-coredns-plugin/api"
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/peer"
+)
+
+func TestSortRFC6724(t *testing.T) {
+	tests := []struct {
+		name   string
+		client string
+		ips    []string
+		want   []string
+	}{
+		{
+			name:   "matching family",
+			client: "2001:db8::100",
+			ips:    []string{"192.0.2.1", "2001:db8::1"},
+			want:   []string{"2001:db8::1", "192.0.2.1"},
+		},
+		{
+			name:   "ipv4 client",
+			client: "198.51.100.7",
+			ips:    []string{"2001:db8::1", "192.0.2.1"},
+			want:   []string{"192.0.2.1", "2001:db8::1"},
+		},
+		{
+			name:   "matching scope",
+			client: "fe80::1",
+			ips:    []string{"2001:db8::1", "fe80::2"},
+			want:   []string{"fe80::2", "2001:db8::1"},
+		},
+		{
+			name:   "longest prefix",
+			client: "10.1.2.3",
+			ips:    []string{"10.9.0.1", "10.1.2.200", "10.1.9.1"},
+			want:   []string{"10.1.2.200", "10.1.9.1", "10.9.0.1"},
+		},
+		{
+			name:   "ties keep the order",
+			client: "10.1.2.3",
+			ips:    []string{"192.0.2.2", "192.0.2.1"},
+			want:   []string{"192.0.2.2", "192.0.2.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ipStrings(sortRFC6724(ips(tt.ips...), net.ParseIP(tt.client)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortRFC6724() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommonPrefixLen(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "10.0.0.1", b: "10.0.0.1", want: 32},
+		{a: "10.0.0.1", b: "10.0.0.2", want: 30},
+		{a: "10.0.0.1", b: "138.0.0.1", want: 0},
+		{a: "2001:db8::1", b: "2001:db8:8000::1", want: 32},
+		{a: "10.0.0.1", b: "2001:db8::1", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"-"+tt.b, func(t *testing.T) {
+			if got := commonPrefixLen(net.ParseIP(tt.a), net.ParseIP(tt.b)); got != tt.want {
+				t.Errorf("commonPrefixLen() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFamilyOrder(t *testing.T) {
+	tests := []struct {
+		order   string
+		want    familyOrder
+		wantErr bool
+	}{
+		{order: "", want: familyAsIs},
+		{order: "none", want: familyAsIs},
+		{order: "ipv4", want: familyIPv4First},
+		{order: "ipv6", want: familyIPv6First},
+		{order: "interleave", want: familyInterleave},
+		{order: "IPv4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			got, err := parseFamilyOrder(tt.order)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFamilyOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseFamilyOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderFamilies(t *testing.T) {
+	mixed := ips("fd00::1", "10.0.0.1", "10.0.0.2", "fd00::2", "10.0.0.3")
+	tests := []struct {
+		name  string
+		ips   []net.IP
+		order familyOrder
+		want  []net.IP
+	}{
+		{name: "as is", ips: mixed, order: familyAsIs, want: mixed},
+		{name: "ipv4 first", ips: mixed, order: familyIPv4First, want: ips("10.0.0.1", "10.0.0.2", "10.0.0.3", "fd00::1", "fd00::2")},
+		{name: "ipv6 first", ips: mixed, order: familyIPv6First, want: ips("fd00::1", "fd00::2", "10.0.0.1", "10.0.0.2", "10.0.0.3")},
+		{name: "interleave", ips: mixed, order: familyInterleave, want: ips("fd00::1", "10.0.0.1", "fd00::2", "10.0.0.2", "10.0.0.3")},
+		{name: "interleave from ipv4", ips: ips("10.0.0.1", "10.0.0.2", "fd00::1"), order: familyInterleave, want: ips("10.0.0.1", "fd00::1", "10.0.0.2")},
+		{name: "single family", ips: ips("10.0.0.2", "10.0.0.1"), order: familyIPv6First, want: ips("10.0.0.2", "10.0.0.1")},
+		{name: "single address", ips: ips("fd00::1"), order: familyIPv4First, want: ips("fd00::1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderFamilies(tt.ips, tt.order); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderFamilies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRFC6724Answers(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("2001:db8::1", "192.0.2.1")}}, nil)
+	h.rfc6724 = true
+	tests := []struct {
+		name    string
+		peer    string
+		request *dns.Msg
+		want    []string
+	}{
+		{name: "ipv4 peer", peer: "198.51.100.7", request: new(dns.Msg), want: []string{"192.0.2.1", "2001:db8::1"}},
+		{name: "ipv6 peer", peer: "2001:db8::100", request: new(dns.Msg), want: []string{"2001:db8::1", "192.0.2.1"}},
+		{name: "ipv6 client of ipv4 peer", peer: "198.51.100.7", request: withSubnet(new(dns.Msg), "2001:db8::", 56), want: []string{"2001:db8::1", "192.0.2.1"}},
+		{name: "ipv4 client of ipv6 peer", peer: "2001:db8::100", request: withSubnet(new(dns.Msg), "198.51.100.0", 24), want: []string{"192.0.2.1", "2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := tt.request.SetQuestion("foo.com.", dns.TypeANY).Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 53}})
+			out, err := h.Query(ctx, &dnsapi.DnsPacket{Msg: in})
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := new(dns.Msg)
+			if err := response.Unpack(out.Msg); err != nil {
+				t.Fatal(err)
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// adminHandler returns the handler of the admin HTTP server. With
+// enablePprof it also serves the net/http/pprof profiles under /debug/pprof/,
+// and with enableImport /admin/import.
+func (h *IstioServiceEntries) adminHandler(enablePprof, enableImport bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/readyz", h.readyz)
+	mux.HandleFunc("/debug/table", h.debugTable)
+	mux.HandleFunc("/admin/zone", h.adminZone)
+	mux.HandleFunc("/admin/config", adminConfig(flag.CommandLine))
+	mux.HandleFunc("/admin/export", h.adminExport)
+	mux.Handle("/metrics", promhttp.Handler())
+	if h.stats != nil {
+		mux.Handle("/debug/queries", h.stats)
+	}
+	if h.chaos != nil {
+		mux.Handle("/admin/chaos", h.chaos)
+	}
+	if enableImport {
+		mux.HandleFunc("/admin/import", h.adminImport)
+	}
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// healthz reports liveness; it fails once the service entry watcher stops
+// completing reads.
+func (h *IstioServiceEntries) healthz(w http.ResponseWriter, r *http.Request) {
+	if !h.alive() {
+		http.Error(w, "service entry watcher is not running", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// tableRow is one host of the DNS table as rendered by /debug/table.
+type tableRow struct {
+	Host      string   `json:"host"`
+	Addresses []string `json:"addresses,omitempty"`
+	CNAME     string   `json:"cname,omitempty"`
+}
+
+// debugTable streams the DNS table as newline delimited JSON, one host per
+// line. The optional host query parameter restricts the output to one host.
+func (h *IstioServiceEntries) debugTable(w http.ResponseWriter, r *http.Request) {
+	table := h.snapshot()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+
+	write := func(key string, entry *dnsEntry) bool {
+		row := tableRow{Host: key, Addresses: ipStrings(entry.vips), CNAME: entry.cname}
+		if err := enc.Encode(row); err != nil {
+			log.Printf("Failed to write DNS table: %v\n", err)
+			return false
+		}
+		return true
+	}
+
+	if host := r.URL.Query().Get("host"); host != "" {
+		key := tableKey(host)
+		if entry, found := table[key]; found {
+			write(key, entry)
+		}
+		return
+	}
+	for key, entry := range table {
+		if !write(key, entry) {
+			return
+		}
+	}
+}
+
+// adminZone renders the DNS table as a zone file. The optional origin query
+// parameter restricts the output to the names under it.
+func (h *IstioServiceEntries) adminZone(w http.ResponseWriter, r *http.Request) {
+	origin := r.URL.Query().Get("origin")
+	if origin == "" {
+		origin = "."
+	}
+	w.Header().Set("Content-Type", "text/dns")
+	if err := h.writeZone(w, origin); err != nil {
+		log.Printf("Failed to write zone %s: %v\n", origin, err)
+	}
+}
+
+// adminConfig returns a handler rendering the value of every flag of flags,
+// including defaults, as a JSON object. Flags naming files, such as
+// --kubeconfig, show the path only. The values of flags whose names suggest
+// credentials are redacted.
+func adminConfig(flags *flag.FlagSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := make(map[string]string)
+		flags.VisitAll(func(f *flag.Flag) {
+			value := f.Value.String()
+			if value != "" && isSecretFlag(f.Name) {
+				value = "REDACTED"
+			}
+			config[f.Name] = value
+		})
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(config); err != nil {
+			log.Printf("Failed to write config: %v\n", err)
+		}
+	}
+}
+
+func isSecretFlag(name string) bool {
+	for _, s := range []string{"password", "secret", "token"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDebugTable(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		"foo.com.":   {vips: ips("10.0.0.1", "10.0.0.2")},
+		"alias.com.": {cname: "foo.com."},
+		".wild.com.": {vips: ips("10.0.0.3")},
+	}, nil)
+	tests := []struct {
+		name  string
+		query string
+		want  []tableRow
+	}{
+		{
+			name: "all",
+			want: []tableRow{
+				{Host: ".wild.com.", Addresses: []string{"10.0.0.3"}},
+				{Host: "alias.com.", CNAME: "foo.com."},
+				{Host: "foo.com.", Addresses: []string{"10.0.0.1", "10.0.0.2"}},
+			},
+		},
+		{name: "host", query: "?host=Foo.com", want: []tableRow{{Host: "foo.com.", Addresses: []string{"10.0.0.1", "10.0.0.2"}}}},
+		{name: "wildcard host", query: "?host=*.wild.com", want: []tableRow{{Host: ".wild.com.", Addresses: []string{"10.0.0.3"}}}},
+		{name: "unknown host", query: "?host=bar.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.debugTable(w, httptest.NewRequest(http.MethodGet, "/debug/table"+tt.query, nil))
+			var got []tableRow
+			dec := json.NewDecoder(strings.NewReader(w.Body.String()))
+			for dec.More() {
+				var row tableRow
+				if err := dec.Decode(&row); err != nil {
+					t.Fatalf("invalid line: %v", err)
+				}
+				got = append(got, row)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Host < got[j].Host })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminPprof(t *testing.T) {
+	tests := []struct {
+		name   string
+		enable bool
+		want   int
+	}{
+		{name: "enabled", enable: true, want: http.StatusOK},
+		{name: "disabled", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(nil, nil)
+			w := httptest.NewRecorder()
+			h.adminHandler(tt.enable, false).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("api-token", "s3cret", "")
+	flags.String("db-password", "", "")
+	flags.String("kubeconfig", "/etc/kube/config", "")
+	flags.Int("max-answers", 8, "")
+	w := httptest.NewRecorder()
+	adminConfig(flags)(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	var config map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	want := map[string]string{
+		"api-token":   "REDACTED",
+		"db-password": "",
+		"kubeconfig":  "/etc/kube/config",
+		"max-answers": "8",
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %v, want %v", config, want)
+	}
+}
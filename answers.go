@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// maxAnswersAnnotation overrides --max-answers for the hosts of one
+// ServiceEntry.
+const maxAnswersAnnotation = "coredns.istio.io/max-answers"
+
+// stableAnnotation makes the hosts of one ServiceEntry answer like
+// --stable-answers.
+const stableAnnotation = "coredns.istio.io/stable-answers"
+
+// orderedAnnotation makes the hosts of one ServiceEntry answer their
+// addresses in the order listed, without rotation or reordering.
+const orderedAnnotation = "coredns.istio.io/ordered-answers"
+
+// entryMaxAnswers returns the answer cap set by the annotation of a
+// ServiceEntry, or 0 if it has none.
+func entryMaxAnswers(name string, namespace string, annotations map[string]string) int {
+	value, found := annotations[maxAnswersAnnotation]
+	if !found {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Ignoring %s annotation on %s.%s: %q is not a positive number\n", maxAnswersAnnotation, name, namespace, value)
+		return 0
+	}
+	return n
+}
+
+// answerCap returns the maximum number of address records answered for the
+// entry, or 0 if it is unbounded.
+func (h *IstioServiceEntries) answerCap(entry *dnsEntry) int {
+	if entry.maxAnswers > 0 {
+		return entry.maxAnswers
+	}
+	return h.maxAnswers
+}
+
+// capAnswers returns at most limit of the records, starting at a position
+// that advances with every query of the entry so that successive answers
+// cover all records. The records keep their relative order. name is only
+// logged.
+func capAnswers(name string, entry *dnsEntry, records []dns.RR, limit int) []dns.RR {
+	if limit <= 0 || len(records) <= limit {
+		return records
+	}
+	log.Printf("Truncating %d answers for %s to %d\n", len(records), name, limit)
+	start := int((atomic.AddUint32(&entry.rotation, 1) - 1) % uint32(len(records)))
+	capped := make([]dns.RR, 0, limit)
+	for i := range records {
+		// offset of record i in the window starting at start
+		if (i-start+len(records))%len(records) < limit {
+			capped = append(capped, records[i])
+		}
+	}
+	return capped
+}
+
+// keepFamilies returns capped, a selection of records, with an address of
+// each family in records. If capped lacks one, its last record of the other
+// family is replaced with the first record of the missing family, so that
+// the answer keeps its size and order otherwise. Selections of a single
+// record are returned unchanged.
+func keepFamilies(records []dns.RR, capped []dns.RR) []dns.RR {
+	if len(capped) < 2 {
+		return capped
+	}
+	for _, missing := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if hasType(capped, missing) {
+			continue
+		}
+		for _, rr := range records {
+			if rr.Header().Rrtype == missing {
+				kept := make([]dns.RR, len(capped))
+				copy(kept, capped)
+				kept[len(kept)-1] = rr
+				return kept
+			}
+		}
+	}
+	return capped
+}
+
+func hasType(records []dns.RR, rrtype uint16) bool {
+	for _, rr := range records {
+		if rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedIPs returns a sorted copy of ips, so that the same set of addresses
+// is always answered in the same order.
+func sortedIPs(ips []net.IP) []net.IP {
+	sorted := make([]net.IP, len(ips))
+	copy(sorted, ips)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].To16(), sorted[j].To16()) < 0
+	})
+	return sorted
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestEntryMaxAnswers(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{value: "", want: 0},
+		{value: "2", want: 2},
+		{value: "0", want: 0},
+		{value: "-1", want: 0},
+		{value: "many", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.value != "" {
+				annotations[maxAnswersAnnotation] = tt.value
+			}
+			if got := entryMaxAnswers("foo", "default", annotations); got != tt.want {
+				t.Errorf("entryMaxAnswers() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapAnswers(t *testing.T) {
+	records := a("foo.com.", ips("10.0.0.1", "10.0.0.2", "10.0.0.3"), 60)
+	tests := []struct {
+		name  string
+		limit int
+		// want holds the answers of successive queries
+		want [][]string
+	}{
+		{name: "unbounded", want: [][]string{{"10.0.0.1", "10.0.0.2", "10.0.0.3"}}},
+		{name: "within cap", limit: 3, want: [][]string{{"10.0.0.1", "10.0.0.2", "10.0.0.3"}}},
+		{name: "rotates", limit: 1, want: [][]string{{"10.0.0.1"}, {"10.0.0.2"}, {"10.0.0.3"}, {"10.0.0.1"}}},
+		{name: "keeps the order", limit: 2, want: [][]string{{"10.0.0.1", "10.0.0.2"}, {"10.0.0.2", "10.0.0.3"}, {"10.0.0.1", "10.0.0.3"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &dnsEntry{}
+			for i, want := range tt.want {
+				if got := rdata(capAnswers("foo.com.", entry, records, tt.limit)); !reflect.DeepEqual(got, want) {
+					t.Errorf("query %d: answer = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCapRotationAcrossReads(t *testing.T) {
+	h := newStoreHandle(t, serviceEntry("foo", map[string]string{maxAnswersAnnotation: "1"}, &networking.ServiceEntry{
+		Hosts: []string{"foo.com"}, Addresses: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+		Resolution: networking.ServiceEntry_STATIC, Endpoints: []*networking.ServiceEntry_Endpoint{{Address: "192.0.2.1"}},
+	}))
+	// every query is followed by a read rebuilding the table
+	var got []string
+	for i := 0; i < 3; i++ {
+		h.readServiceEntries("")
+		got = append(got, rdata(query(t, h, "foo.com.", dns.TypeA).Answer)...)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("answers = %v, want %v", got, want)
+	}
+}
+
+func TestStableAnswers(t *testing.T) {
+	tests := []struct {
+		name          string
+		stableAnswers bool
+		annotations   map[string]string
+		// want holds the answers of successive queries
+		want [][]string
+	}{
+		{
+			name:        "rotated",
+			annotations: map[string]string{maxAnswersAnnotation: "1"},
+			want:        [][]string{{"10.0.0.3"}, {"10.0.0.1"}},
+		},
+		{
+			name:          "flag",
+			stableAnswers: true,
+			annotations:   map[string]string{maxAnswersAnnotation: "1"},
+			want:          [][]string{{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, {"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		},
+		{
+			name:        "annotation",
+			annotations: map[string]string{maxAnswersAnnotation: "1", stableAnnotation: "true"},
+			want:        [][]string{{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, {"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newStoreHandle(t, serviceEntry("foo", tt.annotations, &networking.ServiceEntry{
+				Hosts: []string{"foo.com"}, Addresses: []string{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+				Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+				Resolution: networking.ServiceEntry_DNS,
+			}))
+			h.stableAnswers = tt.stableAnswers
+			h.readServiceEntries("")
+			for i, want := range tt.want {
+				if got := rdata(query(t, h, "foo.com.", dns.TypeA).Answer); !reflect.DeepEqual(got, want) {
+					t.Errorf("query %d: answer = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderedAnswers(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		setup       func(h *IstioServiceEntries)
+		// want holds the answers of successive queries
+		want [][]string
+	}{
+		{
+			name:        "as listed",
+			annotations: map[string]string{orderedAnnotation: "true"},
+			want:        [][]string{{"10.0.0.3", "10.0.0.1", "10.0.0.2"}, {"10.0.0.3", "10.0.0.1", "10.0.0.2"}},
+		},
+		{
+			name:        "capped to the primaries",
+			annotations: map[string]string{orderedAnnotation: "true", maxAnswersAnnotation: "2"},
+			want:        [][]string{{"10.0.0.3", "10.0.0.1"}, {"10.0.0.3", "10.0.0.1"}},
+		},
+		{
+			name:        "over stable answers",
+			annotations: map[string]string{orderedAnnotation: "true"},
+			setup:       func(h *IstioServiceEntries) { h.stableAnswers = true },
+			want:        [][]string{{"10.0.0.3", "10.0.0.1", "10.0.0.2"}},
+		},
+		{
+			name:        "rotated without the annotation",
+			annotations: map[string]string{maxAnswersAnnotation: "2"},
+			want:        [][]string{{"10.0.0.3", "10.0.0.1"}, {"10.0.0.1", "10.0.0.2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newStoreHandle(t, serviceEntry("foo", tt.annotations, &networking.ServiceEntry{
+				Hosts: []string{"foo.com"}, Addresses: []string{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+				Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+				Resolution: networking.ServiceEntry_DNS,
+			}))
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+			h.readServiceEntries("")
+			for i, want := range tt.want {
+				if got := rdata(query(t, h, "foo.com.", dns.TypeA).Answer); !reflect.DeepEqual(got, want) {
+					t.Errorf("query %d: answer = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeepFamilies(t *testing.T) {
+	records := addressRecords("foo.com.", dns.TypeANY, ips("10.0.0.1", "10.0.0.2", "10.0.0.3", "fd00::1"), 60)
+	tests := []struct {
+		name   string
+		capped []dns.RR
+		want   []string
+	}{
+		{name: "single record", capped: records[:1], want: []string{"10.0.0.1"}},
+		{name: "missing IPv6", capped: records[:2], want: []string{"10.0.0.1", "fd00::1"}},
+		{name: "both present", capped: records[2:], want: []string{"10.0.0.3", "fd00::1"}},
+		{name: "missing IPv4", capped: []dns.RR{records[3], records[3]}, want: []string{"fd00::1", "10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rdata(keepFamilies(records, tt.capped)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keepFamilies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBothFamilies(t *testing.T) {
+	tests := []struct {
+		name         string
+		bothFamilies bool
+		qtype        uint16
+		want         []string
+	}{
+		{name: "off", qtype: dns.TypeANY, want: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "any", bothFamilies: true, qtype: dns.TypeANY, want: []string{"10.0.0.1", "fd00::1"}},
+		{name: "single family", bothFamilies: true, qtype: dns.TypeA, want: []string{"10.0.0.1", "10.0.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1", "10.0.0.2", "10.0.0.3", "fd00::1"), maxAnswers: 2}}, nil)
+			h.bothFamilies = tt.bothFamilies
+			if got := rdata(query(t, h, "foo.com.", tt.qtype).Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
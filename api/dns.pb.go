@@ -34,7 +34,7 @@ func (m *DnsPacket) Reset()         { *m = DnsPacket{} }
 func (m *DnsPacket) String() string { return proto.CompactTextString(m) }
 func (*DnsPacket) ProtoMessage()    {}
 func (*DnsPacket) Descriptor() ([]byte, []int) {
-	return fileDescriptor_dns_0ce1ad1058f4f2af, []int{0}
+	return fileDescriptor_dns_6c2406027bddeec2, []int{0}
 }
 func (m *DnsPacket) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_DnsPacket.Unmarshal(m, b)
@@ -61,8 +61,47 @@ func (m *DnsPacket) GetMsg() []byte {
 	return nil
 }
 
+type DnsBatch struct {
+	Packets              []*DnsPacket `protobuf:"bytes,1,rep,name=packets,proto3" json:"packets,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *DnsBatch) Reset()         { *m = DnsBatch{} }
+func (m *DnsBatch) String() string { return proto.CompactTextString(m) }
+func (*DnsBatch) ProtoMessage()    {}
+func (*DnsBatch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_dns_6c2406027bddeec2, []int{1}
+}
+func (m *DnsBatch) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DnsBatch.Unmarshal(m, b)
+}
+func (m *DnsBatch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DnsBatch.Marshal(b, m, deterministic)
+}
+func (dst *DnsBatch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DnsBatch.Merge(dst, src)
+}
+func (m *DnsBatch) XXX_Size() int {
+	return xxx_messageInfo_DnsBatch.Size(m)
+}
+func (m *DnsBatch) XXX_DiscardUnknown() {
+	xxx_messageInfo_DnsBatch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DnsBatch proto.InternalMessageInfo
+
+func (m *DnsBatch) GetPackets() []*DnsPacket {
+	if m != nil {
+		return m.Packets
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*DnsPacket)(nil), "coredns.dns.DnsPacket")
+	proto.RegisterType((*DnsBatch)(nil), "coredns.dns.DnsBatch")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -78,6 +117,7 @@ const _ = grpc.SupportPackageIsVersion4
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type DnsServiceClient interface {
 	Query(ctx context.Context, in *DnsPacket, opts ...grpc.CallOption) (*DnsPacket, error)
+	BatchQuery(ctx context.Context, in *DnsBatch, opts ...grpc.CallOption) (*DnsBatch, error)
 }
 
 type dnsServiceClient struct {
@@ -97,9 +137,19 @@ func (c *dnsServiceClient) Query(ctx context.Context, in *DnsPacket, opts ...grp
 	return out, nil
 }
 
+func (c *dnsServiceClient) BatchQuery(ctx context.Context, in *DnsBatch, opts ...grpc.CallOption) (*DnsBatch, error) {
+	out := new(DnsBatch)
+	err := c.cc.Invoke(ctx, "/coredns.dns.DnsService/BatchQuery", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DnsServiceServer is the server API for DnsService service.
 type DnsServiceServer interface {
 	Query(context.Context, *DnsPacket) (*DnsPacket, error)
+	BatchQuery(context.Context, *DnsBatch) (*DnsBatch, error)
 }
 
 func RegisterDnsServiceServer(s *grpc.Server, srv DnsServiceServer) {
@@ -124,6 +174,24 @@ func _DnsService_Query_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DnsService_BatchQuery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DnsBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnsServiceServer).BatchQuery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/coredns.dns.DnsService/BatchQuery",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnsServiceServer).BatchQuery(ctx, req.(*DnsBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _DnsService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "coredns.dns.DnsService",
 	HandlerType: (*DnsServiceServer)(nil),
@@ -132,21 +200,28 @@ var _DnsService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Query",
 			Handler:    _DnsService_Query_Handler,
 		},
+		{
+			MethodName: "BatchQuery",
+			Handler:    _DnsService_BatchQuery_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "dns.proto",
 }
 
-func init() { proto.RegisterFile("dns.proto", fileDescriptor_dns_0ce1ad1058f4f2af) }
+func init() { proto.RegisterFile("dns.proto", fileDescriptor_dns_6c2406027bddeec2) }
 
-var fileDescriptor_dns_0ce1ad1058f4f2af = []byte{
-	// 120 bytes of a gzipped FileDescriptorProto
+var fileDescriptor_dns_6c2406027bddeec2 = []byte{
+	// 167 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4c, 0xc9, 0x2b, 0xd6,
 	0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x4e, 0xce, 0x2f, 0x4a, 0x05, 0x71, 0x53, 0xf2, 0x8a,
 	0x95, 0x64, 0xb9, 0x38, 0x5d, 0xf2, 0x8a, 0x03, 0x12, 0x93, 0xb3, 0x53, 0x4b, 0x84, 0x04, 0xb8,
-	0x98, 0x73, 0x8b, 0xd3, 0x25, 0x18, 0x15, 0x18, 0x35, 0x78, 0x82, 0x40, 0x4c, 0x23, 0x57, 0x2e,
-	0x2e, 0x97, 0xbc, 0xe2, 0xe0, 0xd4, 0xa2, 0xb2, 0xcc, 0xe4, 0x54, 0x21, 0x73, 0x2e, 0xd6, 0xc0,
-	0xd2, 0xd4, 0xa2, 0x4a, 0x21, 0x31, 0x3d, 0x24, 0x33, 0xf4, 0xe0, 0x06, 0x48, 0xe1, 0x10, 0x77,
-	0x62, 0x89, 0x62, 0x2a, 0x48, 0x4a, 0x62, 0x03, 0xdb, 0x6f, 0x0c, 0x08, 0x00, 0x00, 0xff, 0xff,
-	0xf5, 0xd1, 0x3f, 0x26, 0x8c, 0x00, 0x00, 0x00,
+	0x98, 0x73, 0x8b, 0xd3, 0x25, 0x18, 0x15, 0x18, 0x35, 0x78, 0x82, 0x40, 0x4c, 0x25, 0x1b, 0x2e,
+	0x0e, 0x97, 0xbc, 0x62, 0xa7, 0xc4, 0x92, 0xe4, 0x0c, 0x21, 0x03, 0x2e, 0xf6, 0x02, 0xb0, 0xba,
+	0x62, 0x09, 0x46, 0x05, 0x66, 0x0d, 0x6e, 0x23, 0x31, 0x3d, 0x24, 0x93, 0xf4, 0xe0, 0xc6, 0x04,
+	0xc1, 0x94, 0x19, 0x35, 0x32, 0x72, 0x71, 0xb9, 0xe4, 0x15, 0x07, 0xa7, 0x16, 0x95, 0x65, 0x26,
+	0xa7, 0x0a, 0x99, 0x73, 0xb1, 0x06, 0x96, 0xa6, 0x16, 0x55, 0x0a, 0xe1, 0xd0, 0x28, 0x85, 0x43,
+	0x5c, 0xc8, 0x8a, 0x8b, 0x0b, 0xec, 0x04, 0x88, 0x6e, 0x51, 0x74, 0x55, 0x60, 0x39, 0x29, 0xec,
+	0xc2, 0x4e, 0x2c, 0x51, 0x4c, 0x05, 0x49, 0x49, 0x6c, 0x60, 0xaf, 0x1b, 0x03, 0x06, 0x00, 0xbd,
+	0xd4, 0x32, 0xa9, 0x07, 0x01, 0x00, 0x00,
 }
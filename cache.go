@@ -0,0 +1,189 @@
+package main
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var answerCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "istio_coredns_answer_cache_lookups_total",
+	Help: "Number of answer cache lookups by cache, positive or negative, and result, hit or miss.",
+}, []string{"cache", "result"})
+
+var answerCacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "istio_coredns_answer_cache_evictions_total",
+	Help: "Number of answers evicted from a full answer cache by cache, positive or negative.",
+}, []string{"cache"})
+
+func init() {
+	prometheus.MustRegister(answerCacheLookups, answerCacheEvictions)
+}
+
+// answerKey identifies an address answer: the question as asked, and the
+// parts of the query context the answer depends on.
+type answerKey struct {
+	name   string
+	qtype  uint16
+	tenant string
+	tagged bool
+	// namespace is set only if hosts are limited to those exported to it
+	namespace string
+	scoped    bool
+	// client is set only if answers are ordered for the client address
+	client string
+}
+
+type cachedAnswer struct {
+	records []dns.RR
+	// nodata marks a negative answer for a host the table has, without
+	// addresses of the queried family
+	nodata  bool
+	expires time.Time
+}
+
+// lruEntry is an answer in the recency list of an answerCache.
+type lruEntry struct {
+	key    answerKey
+	answer cachedAnswer
+}
+
+// answerCache holds the address answers assembled from one version of the
+// DNS table, either positive answers or negative ones. New caches are
+// started whenever the table changes, so answers never outlive the data
+// they were built from. When full, the least recently used answer is
+// evicted, so that the positive and negative caches, each bounded by its
+// own size, never evict each other's answers.
+type answerCache struct {
+	// kind is the cache label of the metrics
+	kind string
+	mu   sync.Mutex
+	// answers holds the element of each key in lru, which lists the
+	// answers most recently used first
+	answers map[answerKey]*list.Element
+	lru     *list.List
+	size    int
+	// ttl is how long negative answers are cached
+	ttl time.Duration
+}
+
+// newAnswerCache returns a cache of at most size answers, or nil, which
+// caches nothing, if size is 0.
+func newAnswerCache(kind string, size int, ttl time.Duration) *answerCache {
+	if size <= 0 {
+		return nil
+	}
+	return &answerCache{kind: kind, answers: make(map[answerKey]*list.Element), lru: list.New(), size: size, ttl: ttl}
+}
+
+// resetCaches starts empty answer caches; the caller holds mapMutex.
+func (h *IstioServiceEntries) resetCaches() {
+	h.answers = newAnswerCache("positive", h.answerCacheSize, 0)
+	h.negatives = newAnswerCache("negative", h.negativeCacheSize, h.negativeCacheTTL)
+}
+
+// lookup returns the cached answer for key, if it has not expired.
+func (c *answerCache) lookup(key answerKey, now time.Time) (cachedAnswer, bool) {
+	if c == nil {
+		return cachedAnswer{}, false
+	}
+	c.mu.Lock()
+	var cached cachedAnswer
+	elem, found := c.answers[key]
+	if found {
+		cached = elem.Value.(*lruEntry).answer
+		if now.Before(cached.expires) {
+			c.lru.MoveToFront(elem)
+		} else {
+			c.lru.Remove(elem)
+			delete(c.answers, key)
+			found = false
+		}
+	}
+	c.mu.Unlock()
+	if !found {
+		answerCacheLookups.WithLabelValues(c.kind, "miss").Inc()
+		return cachedAnswer{}, false
+	}
+	answerCacheLookups.WithLabelValues(c.kind, "hit").Inc()
+	return cached, true
+}
+
+// get returns the cached records for key, if they have not expired.
+func (c *answerCache) get(key answerKey, now time.Time) ([]dns.RR, bool) {
+	cached, found := c.lookup(key, now)
+	if !found {
+		return nil, false
+	}
+	// callers may append to the answer
+	return append([]dns.RR(nil), cached.records...), true
+}
+
+// put caches the records for the lowest TTL among them. Empty answers are
+// not cached.
+func (c *answerCache) put(key answerKey, records []dns.RR, now time.Time) {
+	if c == nil || len(records) == 0 {
+		return
+	}
+	ttl := records[0].Header().Ttl
+	for _, rr := range records[1:] {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	if ttl == 0 {
+		return
+	}
+	c.store(key, cachedAnswer{
+		records: append([]dns.RR(nil), records...),
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	})
+}
+
+// putNegative caches that the table has no addresses for key, for the ttl
+// of the cache: none of the queried family if nodata is set, and no host
+// otherwise.
+func (c *answerCache) putNegative(key answerKey, nodata bool, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.store(key, cachedAnswer{nodata: nodata, expires: now.Add(c.ttl)})
+}
+
+// store adds an answer as the most recently used one. When the cache is
+// full, the least recently used answer is evicted.
+func (c *answerCache) store(key answerKey, answer cachedAnswer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, found := c.answers[key]; found {
+		elem.Value.(*lruEntry).answer = answer
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.answers[key] = c.lru.PushFront(&lruEntry{key: key, answer: answer})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.answers, oldest.Value.(*lruEntry).key)
+		answerCacheEvictions.WithLabelValues(c.kind).Inc()
+	}
+}
+
+// answerKey returns the cache key of the address question q of request.
+func (h *IstioServiceEntries) answerKey(request *dns.Msg, client net.IP, q dns.Question) answerKey {
+	key := answerKey{name: q.Name, qtype: q.Qtype}
+	if h.tenantLabel != "" {
+		key.tenant, key.tagged = requestTenant(request, h.tenantOption)
+	}
+	if h.namespaceOption != 0 {
+		key.namespace, key.scoped = requestNamespace(request, h.namespaceOption)
+	}
+	if h.rfc6724 && client != nil {
+		key.client = client.String()
+	}
+	return key
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestAnswerCache(t *testing.T) {
+	now := time.Now()
+	key := answerKey{name: "foo.com.", qtype: dns.TypeA}
+	tests := []struct {
+		name    string
+		size    int
+		records []dns.RR
+		at      time.Duration
+		want    []string
+		found   bool
+	}{
+		{name: "disabled", records: a("foo.com.", ips("10.0.0.1"), 60)},
+		{name: "hit", size: 1, records: a("foo.com.", ips("10.0.0.1"), 60), want: []string{"10.0.0.1"}, found: true},
+		{name: "lowest TTL", size: 1, records: append(a("foo.com.", ips("10.0.0.1"), 60), a("foo.com.", ips("10.0.0.2"), 5)...), at: 10 * time.Second},
+		{name: "expired", size: 1, records: a("foo.com.", ips("10.0.0.1"), 60), at: time.Minute},
+		{name: "zero TTL", size: 1, records: a("foo.com.", ips("10.0.0.1"), 0)},
+		{name: "empty", size: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newAnswerCache("positive", tt.size, 0)
+			c.put(key, tt.records, now)
+			got, found := c.get(key, now.Add(tt.at))
+			if found != tt.found || !reflect.DeepEqual(rdata(got), tt.want) {
+				t.Errorf("get() = %v, %v, want %v, %v", rdata(got), found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestAnswerCacheLRU(t *testing.T) {
+	now := time.Now()
+	key := func(name string) answerKey { return answerKey{name: name, qtype: dns.TypeA} }
+	tests := []struct {
+		name string
+		// ops are put, or get if prefixed with ?, of the names in order
+		ops  []string
+		want []string
+	}{
+		{name: "oldest evicted", ops: []string{"a", "b", "c"}, want: []string{"b", "c"}},
+		{name: "used kept", ops: []string{"a", "b", "?a", "c"}, want: []string{"a", "c"}},
+		{name: "updated kept", ops: []string{"a", "b", "a", "c"}, want: []string{"a", "c"}},
+		{name: "within size", ops: []string{"a", "b"}, want: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newAnswerCache("positive", 2, 0)
+			for _, op := range tt.ops {
+				if op[0] == '?' {
+					c.get(key(op[1:]), now)
+					continue
+				}
+				c.put(key(op), a(op+".", ips("10.0.0.1"), 60), now)
+			}
+			var cached []string
+			for _, name := range []string{"a", "b", "c"} {
+				if _, found := c.get(key(name), now); found {
+					cached = append(cached, name)
+				}
+			}
+			if !reflect.DeepEqual(cached, tt.want) {
+				t.Errorf("cached = %v, want %v", cached, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnswerCacheQueries(t *testing.T) {
+	entry := &dnsEntry{vips: ips("10.0.0.1")}
+	h := newTestHandle(map[string]*dnsEntry{"foo.com.": entry}, nil)
+	h.answerCacheSize = 10
+	h.resetCaches()
+	for i, want := range [][]string{{"10.0.0.1"}, {"10.0.0.1"}} {
+		t.Run(fmt.Sprintf("query %d", i), func(t *testing.T) {
+			if got := rdata(query(t, h, "foo.com.", dns.TypeA).Answer); !reflect.DeepEqual(got, want) {
+				t.Errorf("answer = %v, want %v", got, want)
+			}
+			// answers come from the cache until the table changes
+			entry.vips = ips("10.0.0.2")
+		})
+	}
+	h.resetCaches()
+	if got, want := rdata(query(t, h, "foo.com.", dns.TypeA).Answer), []string{"10.0.0.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("answer after reset = %v, want %v", got, want)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	now := time.Now()
+	key := answerKey{name: "foo.com.", qtype: dns.TypeAAAA}
+	tests := []struct {
+		name   string
+		size   int
+		nodata bool
+		at     time.Duration
+		found  bool
+	}{
+		{name: "disabled"},
+		{name: "no host", size: 1, found: true},
+		{name: "no data", size: 1, nodata: true, found: true},
+		{name: "expired", size: 1, at: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newAnswerCache("negative", tt.size, 5*time.Second)
+			c.putNegative(key, tt.nodata, now)
+			cached, found := c.lookup(key, now.Add(tt.at))
+			if found != tt.found || cached.nodata != (tt.found && tt.nodata) {
+				t.Errorf("lookup() = %+v, %v, want nodata %v, %v", cached, found, tt.nodata, tt.found)
+			}
+		})
+	}
+}
+
+func TestSeparateCaches(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		"a.com.": {vips: ips("10.0.0.1")},
+		"b.com.": {vips: ips("10.0.0.2")},
+	}, nil)
+	h.answerCacheSize = 2
+	h.negativeCacheSize = 1
+	h.negativeCacheTTL = time.Minute
+	h.resetCaches()
+	positive := metricValue(t, answerCacheEvictions.WithLabelValues("positive"))
+	negative := metricValue(t, answerCacheEvictions.WithLabelValues("negative"))
+
+	for _, q := range []dns.Question{
+		{Name: "a.com.", Qtype: dns.TypeA},
+		{Name: "b.com.", Qtype: dns.TypeA},
+		// negative answers, evicting each other only
+		{Name: "a.com.", Qtype: dns.TypeAAAA},
+		{Name: "c.com.", Qtype: dns.TypeA},
+		{Name: "d.com.", Qtype: dns.TypeA},
+	} {
+		query(t, h, q.Name, q.Qtype)
+	}
+	v := h.view()
+	for _, tt := range []struct {
+		cache *answerCache
+		name  string
+		qtype uint16
+		found bool
+	}{
+		{cache: v.answers, name: "a.com.", qtype: dns.TypeA, found: true},
+		{cache: v.answers, name: "b.com.", qtype: dns.TypeA, found: true},
+		{cache: v.negatives, name: "a.com.", qtype: dns.TypeAAAA},
+		{cache: v.negatives, name: "c.com.", qtype: dns.TypeA},
+		{cache: v.negatives, name: "d.com.", qtype: dns.TypeA, found: true},
+	} {
+		if _, found := tt.cache.lookup(answerKey{name: tt.name, qtype: tt.qtype}, time.Now()); found != tt.found {
+			t.Errorf("%s cache has %s %s: %v, want %v", tt.cache.kind, tt.name, dns.TypeToString[tt.qtype], found, tt.found)
+		}
+	}
+	if got := metricValue(t, answerCacheEvictions.WithLabelValues("positive")) - positive; got != 0 {
+		t.Errorf("positive evictions increased by %v, want 0", got)
+	}
+	if got := metricValue(t, answerCacheEvictions.WithLabelValues("negative")) - negative; got != 2 {
+		t.Errorf("negative evictions increased by %v, want 2", got)
+	}
+}
+
+func TestNegativeCacheQueries(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+	h.negativeCacheSize = 10
+	h.negativeCacheTTL = time.Minute
+	h.resetCaches()
+	tests := []struct {
+		qname string
+		qtype uint16
+		rcode int
+	}{
+		{qname: "foo.com.", qtype: dns.TypeAAAA, rcode: dns.RcodeSuccess},
+		{qname: "bar.com.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qname, func(t *testing.T) {
+			// the cached negative answer is the same as the first one
+			for i := 0; i < 2; i++ {
+				response := query(t, h, tt.qname, tt.qtype)
+				if response.Rcode != tt.rcode || len(response.Answer) != 0 {
+					t.Errorf("query %d: rcode = %s, answer = %v, want %s without records", i, dns.RcodeToString[response.Rcode], response.Answer, dns.RcodeToString[tt.rcode])
+				}
+			}
+			if _, found := h.view().negatives.lookup(answerKey{name: tt.qname, qtype: tt.qtype}, time.Now()); !found {
+				t.Errorf("negative answer not cached")
+			}
+		})
+	}
+}
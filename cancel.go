@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/status"
+)
+
+var abandonedQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "istio_coredns_abandoned_queries_total",
+	Help: "Number of queries given up because the client cancelled them or their deadline passed.",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(abandonedQueries)
+}
+
+// abandoned returns the gRPC status error of a query whose client has gone
+// away, either by cancelling the call or by letting its deadline pass, and
+// counts the query. Nobody reads the answer of such a query, so it is not
+// built any further.
+func abandoned(ctx context.Context) error {
+	err := ctx.Err()
+	switch err {
+	case nil:
+		return nil
+	case context.Canceled:
+		abandonedQueries.WithLabelValues("cancelled").Inc()
+	default:
+		abandonedQueries.WithLabelValues("deadline").Inc()
+	}
+	return status.FromContextError(err).Err()
+}
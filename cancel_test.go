@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	dnsapi "github.com/istio-ecosystem/istio-coredns-plugin/api"
+)
+
+func TestAbandoned(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		code   codes.Code
+		reason string
+	}{
+		{name: "active", ctx: context.Background(), code: codes.OK},
+		{name: "cancelled", ctx: cancelled, code: codes.Canceled, reason: "cancelled"},
+		{name: "deadline", ctx: expired, code: codes.DeadlineExceeded, reason: "deadline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before float64
+			if tt.reason != "" {
+				before = metricValue(t, abandonedQueries.WithLabelValues(tt.reason))
+			}
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			in, err := new(dns.Msg).SetQuestion("foo.com.", dns.TypeA).Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := h.Query(tt.ctx, &dnsapi.DnsPacket{Msg: in})
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("Query() error = %v, want code %v", err, tt.code)
+			}
+			if tt.reason == "" {
+				if out == nil {
+					t.Errorf("Query() returned no answer")
+				}
+				return
+			}
+			if got := metricValue(t, abandonedQueries.WithLabelValues(tt.reason)) - before; got != 1 {
+				t.Errorf("abandoned queries increased by %v, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// chaos delays and fails queries for resilience testing of clients. It is
+// only created with --chaos and does nothing until configured through
+// /admin/chaos.
+type chaos struct {
+	mu sync.Mutex
+	// Delay is added to every query, plus a uniformly distributed Jitter
+	Delay  time.Duration `json:"delay"`
+	Jitter time.Duration `json:"jitter"`
+	// ErrorPercent of the queries are answered with Rcode
+	ErrorPercent int    `json:"errorPercent"`
+	Rcode        string `json:"rcode"`
+	rcode        int
+	// random draws the jitter and the failed queries; guarded by mu
+	random *rand.Rand
+}
+
+// newChaos returns chaos that does nothing until configured, drawing from
+// source.
+func newChaos(source rand.Source) *chaos {
+	return &chaos{Rcode: dns.RcodeToString[dns.RcodeServerFailure], rcode: dns.RcodeServerFailure, random: rand.New(source)}
+}
+
+// inject applies the configured delay and reports the rcode to fail the
+// query with, if any.
+func (c *chaos) inject(ctx context.Context) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	delay, rcode := c.Delay, c.rcode
+	if c.Jitter > 0 {
+		delay += time.Duration(c.random.Int63n(int64(c.Jitter)))
+	}
+	fail := c.ErrorPercent > 0 && c.random.Intn(100) < c.ErrorPercent
+	c.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	return rcode, fail
+}
+
+// ServeHTTP shows the chaos settings, and replaces them on POST with the
+// delay, jitter, error-percent and rcode form values.
+func (c *chaos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := c.update(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		log.Printf("Failed to write chaos settings: %v\n", err)
+	}
+}
+
+func (c *chaos) update(r *http.Request) error {
+	var delay, jitter time.Duration
+	var err error
+	if v := r.FormValue("delay"); v != "" {
+		if delay, err = time.ParseDuration(v); err != nil || delay < 0 {
+			return fmt.Errorf("invalid delay %q", v)
+		}
+	}
+	if v := r.FormValue("jitter"); v != "" {
+		if jitter, err = time.ParseDuration(v); err != nil || jitter < 0 {
+			return fmt.Errorf("invalid jitter %q", v)
+		}
+	}
+	errorPercent := 0
+	if v := r.FormValue("error-percent"); v != "" {
+		if errorPercent, err = strconv.Atoi(v); err != nil || errorPercent < 0 || errorPercent > 100 {
+			return fmt.Errorf("invalid error-percent %q, must be between 0 and 100", v)
+		}
+	}
+	name := r.FormValue("rcode")
+	if name == "" {
+		name = dns.RcodeToString[dns.RcodeServerFailure]
+	}
+	rcode, found := dns.StringToRcode[name]
+	if !found {
+		return fmt.Errorf("unknown rcode %q", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Delay, c.Jitter, c.ErrorPercent, c.Rcode, c.rcode = delay, jitter, errorPercent, name, rcode
+	log.Printf("Chaos set to delay %v, jitter %v, %d%% %s\n", delay, jitter, errorPercent, name)
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestChaos(t *testing.T) {
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+		rcode  int
+		// delay is the least time a query takes
+		delay time.Duration
+	}{
+		{name: "off", form: url.Values{}, status: http.StatusOK, rcode: dns.RcodeSuccess},
+		{name: "all fail", form: url.Values{"error-percent": {"100"}}, status: http.StatusOK, rcode: dns.RcodeServerFailure},
+		{name: "all refused", form: url.Values{"error-percent": {"100"}, "rcode": {"REFUSED"}}, status: http.StatusOK, rcode: dns.RcodeRefused},
+		{name: "delayed", form: url.Values{"delay": {"50ms"}, "jitter": {"10ms"}}, status: http.StatusOK, rcode: dns.RcodeSuccess, delay: 50 * time.Millisecond},
+		{name: "bad percent", form: url.Values{"error-percent": {"101"}}, status: http.StatusBadRequest, rcode: dns.RcodeSuccess},
+		{name: "bad delay", form: url.Values{"delay": {"-1s"}}, status: http.StatusBadRequest, rcode: dns.RcodeSuccess},
+		{name: "bad rcode", form: url.Values{"error-percent": {"100"}, "rcode": {"OOPS"}}, status: http.StatusBadRequest, rcode: dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.chaos = newChaos(rand.NewSource(1))
+			r := httptest.NewRequest(http.MethodPost, "/admin/chaos", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			h.adminHandler(false, false).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			start := time.Now()
+			if response := query(t, h, "foo.com.", dns.TypeA); response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			// the upper bound leaves room for slow test machines
+			if elapsed := time.Since(start); elapsed < tt.delay || elapsed > tt.delay+time.Second {
+				t.Errorf("query took %v, want %v to %v", elapsed, tt.delay, tt.delay+time.Second)
+			}
+		})
+	}
+}
+
+func TestChaosErrorPercent(t *testing.T) {
+	const queries = 1000
+	tests := []struct {
+		percent  int
+		min, max int
+	}{
+		{percent: 0, min: 0, max: 0},
+		{percent: 10, min: 70, max: 130},
+		{percent: 30, min: 250, max: 350},
+		{percent: 90, min: 870, max: 930},
+		{percent: 100, min: queries, max: queries},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.percent), func(t *testing.T) {
+			c := newChaos(rand.NewSource(1))
+			c.ErrorPercent = tt.percent
+			failed := 0
+			for i := 0; i < queries; i++ {
+				if rcode, fail := c.inject(context.Background()); fail {
+					if rcode != dns.RcodeServerFailure {
+						t.Fatalf("rcode = %s, want SERVFAIL", dns.RcodeToString[rcode])
+					}
+					failed++
+				}
+			}
+			if failed < tt.min || failed > tt.max {
+				t.Errorf("%d of %d queries failed, want %d to %d", failed, queries, tt.min, tt.max)
+			}
+		})
+	}
+}
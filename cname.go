@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// cnameAnnotation overrides --dns-cname for the hosts of one ServiceEntry.
+const cnameAnnotation = "coredns.istio.io/dns-cname"
+
+// chaseTTL is the TTL of address records resolved while chasing a CNAME; the TTL
+// the upstream resolver used is not known.
+const chaseTTL = 30
+
+// cnameMode controls how DNS resolution ServiceEntries with a single external
+// endpoint are answered.
+type cnameMode int
+
+const (
+	// cnameOff serves the ServiceEntry addresses (or --default-address)
+	cnameOff cnameMode = iota
+	// cnameOnly answers with a CNAME to the endpoint's host name
+	cnameOnly
+	// cnameChase answers with the CNAME followed by the address records of
+	// its target
+	cnameChase
+)
+
+func parseCNAMEMode(mode string) (cnameMode, error) {
+	switch mode {
+	case "", "off":
+		return cnameOff, nil
+	case "cname":
+		return cnameOnly, nil
+	case "chase":
+		return cnameChase, nil
+	}
+	return cnameOff, fmt.Errorf("unknown CNAME mode %q, expected off, cname or chase", mode)
+}
+
+// entryCNAMEMode returns the CNAME mode of a ServiceEntry, taking its
+// annotation into account.
+func (h *IstioServiceEntries) entryCNAMEMode(name string, namespace string, annotations map[string]string) cnameMode {
+	value, found := annotations[cnameAnnotation]
+	if !found {
+		return h.cnameMode
+	}
+	mode, err := parseCNAMEMode(value)
+	if err != nil {
+		log.Printf("Ignoring %s annotation on %s.%s: %v\n", cnameAnnotation, name, namespace, err)
+		return h.cnameMode
+	}
+	return mode
+}
+
+// externalName returns the fully qualified host name of the only endpoint of
+// a DNS resolution ServiceEntry, or "" if the entry is not of that shape.
+func externalName(entry *networking.ServiceEntry) string {
+	if entry.Resolution != networking.ServiceEntry_DNS || len(entry.Endpoints) != 1 {
+		return ""
+	}
+	address := entry.Endpoints[0].Address
+	if address == "" || net.ParseIP(address) != nil || strings.HasPrefix(address, "unix://") {
+		return ""
+	}
+	return dns.Fqdn(address)
+}
+
+// defaultMaxCNAMEChain is the default of --max-cname-chain.
+const defaultMaxCNAMEChain = 8
+
+// errCNAMEChain is returned by chase for chains longer than --max-cname-chain.
+var errCNAMEChain = errors.New("CNAME chain too long")
+
+// chase resolves the address records of type qtype (A, AAAA or ANY for
+// both) of a CNAME target, from the table if it is served here and through
+// the system resolver otherwise. CNAMEs of targets
+// served here are followed and answered too, up to a chain of maxCNAMEChain
+// CNAMEs counting the one of the queried name; longer chains, including
+// loops, fail with errCNAMEChain. Chasing stops with the context error once
+// the query is abandoned.
+func (h *IstioServiceEntries) chase(ctx context.Context, v tableView, target string, qtype uint16) ([]dns.RR, error) {
+	var chain []dns.RR
+	for depth := 1; ; depth++ {
+		entry := v.lookup(target)
+		if entry == nil {
+			break
+		}
+		if entry.cname == "" {
+			return append(chain, addressRecords(target, qtype, entry.vips, h.entryAnswerTTL(entry, target, qtype, ipStrings(entry.vips)))...), nil
+		}
+		if depth >= h.maxCNAMEChain {
+			cnameChainExceeded.Inc()
+			return nil, errCNAMEChain
+		}
+		chain = append(chain, cname(target, entry.cname, h.entryAnswerTTL(entry, target, dns.TypeCNAME, []string{entry.cname}))...)
+		target = entry.cname
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target)
+	if err != nil && ctx.Err() != nil {
+		// the query was abandoned, there is nobody to answer
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		log.Printf("Failed to chase CNAME %s: %v\n", target, err)
+		return chain, nil
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return append(chain, addressRecords(target, qtype, ips, h.floorTTL(chaseTTL))...), nil
+}
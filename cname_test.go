@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestParseCNAMEMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    cnameMode
+		wantErr bool
+	}{
+		{mode: "", want: cnameOff},
+		{mode: "off", want: cnameOff},
+		{mode: "cname", want: cnameOnly},
+		{mode: "chase", want: cnameChase},
+		{mode: "follow", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := parseCNAMEMode(tt.mode)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseCNAMEMode() = %v, %v, want %v with error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExternalName(t *testing.T) {
+	endpoint := func(address string) []*networking.ServiceEntry_Endpoint {
+		return []*networking.ServiceEntry_Endpoint{{Address: address}}
+	}
+	tests := []struct {
+		name  string
+		entry *networking.ServiceEntry
+		want  string
+	}{
+		{name: "dns host", entry: &networking.ServiceEntry{Resolution: networking.ServiceEntry_DNS, Endpoints: endpoint("db.example.com")}, want: "db.example.com."},
+		{name: "static", entry: &networking.ServiceEntry{Resolution: networking.ServiceEntry_STATIC, Endpoints: endpoint("db.example.com")}},
+		{name: "address", entry: &networking.ServiceEntry{Resolution: networking.ServiceEntry_DNS, Endpoints: endpoint("10.0.0.1")}},
+		{name: "unix socket", entry: &networking.ServiceEntry{Resolution: networking.ServiceEntry_DNS, Endpoints: endpoint("unix:///var/run/db.sock")}},
+		{name: "several endpoints", entry: &networking.ServiceEntry{Resolution: networking.ServiceEntry_DNS, Endpoints: append(endpoint("a.example.com"), endpoint("b.example.com")...)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := externalName(tt.entry); got != tt.want {
+				t.Errorf("externalName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCNAMEAnswers(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		"alias.com.":  {cname: "target.com."},
+		"chased.com.": {cname: "target.com.", chase: true},
+		"target.com.": {vips: ips("10.0.0.1", "10.0.0.2", "2001:db8::1")},
+	}, nil)
+	h.maxCNAMEChain = defaultMaxCNAMEChain
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []string
+	}{
+		{name: "alias.com.", qtype: dns.TypeA, want: []string{"target.com."}},
+		{name: "alias.com.", qtype: dns.TypeCNAME, want: []string{"target.com."}},
+		{name: "chased.com.", qtype: dns.TypeA, want: []string{"target.com.", "10.0.0.1", "10.0.0.2"}},
+		{name: "chased.com.", qtype: dns.TypeAAAA, want: []string{"target.com.", "2001:db8::1"}},
+		{name: "chased.com.", qtype: dns.TypeANY, want: []string{"target.com.", "10.0.0.1", "10.0.0.2", "2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			response := query(t, h, tt.name, tt.qtype)
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCNAMEChainLimit(t *testing.T) {
+	dnsEntries := map[string]*dnsEntry{
+		"a.com.":    {cname: "b.com.", chase: true},
+		"b.com.":    {cname: "c.com."},
+		"c.com.":    {vips: ips("10.0.0.1")},
+		"loop.com.": {cname: "pool.com.", chase: true},
+		"pool.com.": {cname: "loop.com."},
+	}
+	tests := []struct {
+		name  string
+		max   int
+		rcode int
+		want  []string
+	}{
+		{name: "a.com.", max: 8, want: []string{"b.com.", "c.com.", "10.0.0.1"}},
+		{name: "a.com.", max: 2, want: []string{"b.com.", "c.com.", "10.0.0.1"}},
+		{name: "a.com.", max: 1, rcode: dns.RcodeServerFailure},
+		{name: "loop.com.", max: 8, rcode: dns.RcodeServerFailure},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s max %d", tt.name, tt.max), func(t *testing.T) {
+			h := newTestHandle(dnsEntries, nil)
+			h.maxCNAMEChain = tt.max
+			response := query(t, h, tt.name, dns.TypeA)
+			if response.Rcode != tt.rcode {
+				t.Fatalf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
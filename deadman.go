@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	kubecfg "istio.io/istio/pkg/kube"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// probeTimeout bounds the time a source probe may take.
+const probeTimeout = 5 * time.Second
+
+// newSourceProbe returns a function checking that the Kubernetes API server
+// the service entries are read from is reachable.
+func newSourceProbe(kubeconfig string, context string) (func() error, error) {
+	config, err := kubecfg.BuildClientConfig(kubeconfig, context)
+	if err != nil {
+		return nil, err
+	}
+	// the probe runs on the watcher, which must not hang on it
+	config.Timeout = probeTimeout
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		_, err := client.Discovery().ServerVersion()
+		return err
+	}, nil
+}
+
+// probeSource records the time of the last successful contact with the
+// source of the service entries.
+func (h *IstioServiceEntries) probeSource() {
+	if h.sourceProbe == nil {
+		return
+	}
+	if err := h.sourceProbe(); err != nil {
+		log.Printf("Kubernetes API server unreachable: %v\n", err)
+		return
+	}
+	atomic.StoreInt64(&h.lastContact, time.Now().UnixNano())
+}
+
+// sourceLost reports whether the source of the service entries has been
+// unreachable for longer than the cutoff, if one is configured.
+func (h *IstioServiceEntries) sourceLost() bool {
+	if h.sourceLossCutoff <= 0 {
+		return false
+	}
+	last := time.Unix(0, atomic.LoadInt64(&h.lastContact))
+	return time.Since(last) > h.sourceLossCutoff
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSourceLost(t *testing.T) {
+	unreachable := func() error { return errors.New("unreachable") }
+	reachable := func() error { return nil }
+	tests := []struct {
+		name        string
+		cutoff      time.Duration
+		probe       func() error
+		lastContact time.Duration
+		rcode       int
+	}{
+		{name: "disabled", probe: unreachable, lastContact: time.Hour},
+		{name: "reachable", cutoff: time.Minute, probe: reachable, lastContact: time.Hour},
+		{name: "briefly unreachable", cutoff: time.Minute, probe: unreachable, lastContact: time.Second},
+		{name: "lost", cutoff: time.Minute, probe: unreachable, lastContact: time.Hour, rcode: dns.RcodeServerFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.sourceLossCutoff = tt.cutoff
+			h.sourceProbe = tt.probe
+			h.lastContact = time.Now().Add(-tt.lastContact).UnixNano()
+			h.probeSource()
+			if response := query(t, h, "foo.com.", dns.TypeA); response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+		})
+	}
+}
+
+func TestProbeBeforeSync(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback bool
+		imported int32
+	}{
+		{name: "fallback table", fallback: true},
+		{name: "imported table", imported: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.fallback = tt.fallback
+			h.imported = tt.imported
+			h.synced = func() bool { return false }
+			probes := 0
+			h.sourceProbe = func() error {
+				probes++
+				return nil
+			}
+			h.readServiceEntries("")
+			if probes != 1 {
+				t.Errorf("probed %d times, want 1", probes)
+			}
+			if h.lastContact == 0 {
+				t.Errorf("last contact not recorded")
+			}
+		})
+	}
+}
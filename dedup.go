@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+)
+
+// dedupIPs drops repeated addresses from ips, keeping the first of each, so
+// that an address listed by several endpoints or sources is answered once.
+// It also returns the number of dropped addresses.
+func dedupIPs(ips []net.IP) ([]net.IP, int) {
+	if len(ips) < 2 {
+		return ips, 0
+	}
+	seen := make(map[string]bool, len(ips))
+	out := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		key := ip.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, ip)
+	}
+	return out, len(ips) - len(out)
+}
+
+// dedupIndex applies dedupIPs to every list of an endpoint index. For the
+// port index this collapses endpoints of the same address and port. It
+// returns the number of dropped addresses.
+func dedupIndex(index map[uint32][]net.IP) int {
+	dropped := 0
+	for k, ips := range index {
+		var n int
+		index[k], n = dedupIPs(ips)
+		dropped += n
+	}
+	return dropped
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestDedupIPs(t *testing.T) {
+	tests := []struct {
+		name    string
+		ips     []net.IP
+		want    []net.IP
+		dropped int
+	}{
+		{name: "empty"},
+		{name: "unique", ips: ips("10.0.0.2", "10.0.0.1"), want: ips("10.0.0.2", "10.0.0.1")},
+		{name: "repeated", ips: ips("10.0.0.2", "10.0.0.1", "10.0.0.2", "10.0.0.2"), want: ips("10.0.0.2", "10.0.0.1"), dropped: 2},
+		{name: "same address in both forms", ips: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.1").To4()}, want: ips("10.0.0.1"), dropped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := dedupIPs(tt.ips)
+			if len(got) != len(tt.want) || dropped != tt.dropped {
+				t.Fatalf("dedupIPs() = %v, %d, want %v, %d", got, dropped, tt.want, tt.dropped)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("dedupIPs() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupIndex(t *testing.T) {
+	index := map[uint32][]net.IP{
+		80:   ips("10.0.0.1", "10.0.0.1", "10.0.0.2"),
+		8080: ips("10.0.0.1"),
+	}
+	if dropped := dedupIndex(index); dropped != 1 {
+		t.Errorf("dedupIndex() dropped %d, want 1", dropped)
+	}
+	want := map[uint32][]net.IP{80: ips("10.0.0.1", "10.0.0.2"), 8080: ips("10.0.0.1")}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("index = %v, want %v", index, want)
+	}
+}
+
+func TestDedupEndpoints(t *testing.T) {
+	tests := []struct {
+		name           string
+		dedupEndpoints bool
+		want           []string
+		wantPort       []string
+		duplicates     float64
+	}{
+		{
+			name:     "kept",
+			want:     []string{"10.0.0.1", "10.0.0.1"},
+			wantPort: []string{"192.0.2.1", "192.0.2.1"},
+		},
+		{
+			name:           "dropped",
+			dedupEndpoints: true,
+			want:           []string{"10.0.0.1"},
+			wantPort:       []string{"192.0.2.1"},
+			duplicates:     2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newStoreHandle(t, serviceEntry("foo", nil, &networking.ServiceEntry{
+				Hosts: []string{"foo.com"}, Addresses: []string{"10.0.0.1", "10.0.0.1"},
+				Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+				Resolution: networking.ServiceEntry_STATIC,
+				Endpoints:  []*networking.ServiceEntry_Endpoint{{Address: "192.0.2.1"}, {Address: "192.0.2.1"}},
+			}))
+			h.dedupEndpoints = tt.dedupEndpoints
+			h.portLabels = true
+			// the same duplicates are found on every read, and counted once
+			for read := 0; read < 2; read++ {
+				h.readServiceEntries("")
+				if got := metricValue(t, duplicateEndpoints); got != tt.duplicates {
+					t.Errorf("read %d: duplicate endpoints = %v, want %v", read, got, tt.duplicates)
+				}
+			}
+			if got := rdata(query(t, h, "foo.com.", dns.TypeA).Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+			if got := rdata(query(t, h, "_80.foo.com.", dns.TypeA).Answer); !reflect.DeepEqual(got, tt.wantPort) {
+				t.Errorf("port answer = %v, want %v", got, tt.wantPort)
+			}
+		})
+	}
+}
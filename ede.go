@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/miekg/dns"
+)
+
+// edeOption is the EDNS0 option code of Extended DNS Errors (RFC 8914). The
+// vendored miekg/dns predates RFC 8914, so the option is sent as a local one.
+const edeOption = 15
+
+// failure is an internal reason for refusing or failing a query.
+type failure int
+
+const (
+	// failureProhibited means the client is not allowed to query
+	failureProhibited failure = iota
+	// failureNotReady means the table is still warming up
+	failureNotReady
+	// failureSourceLost means the service entry source is unreachable
+	failureSourceLost
+)
+
+// extendedErrors maps failures to their RFC 8914 info code and extra text.
+var extendedErrors = map[failure]struct {
+	code uint16
+	text string
+}{
+	failureProhibited: {18, "client not allowed by ACL"},
+	failureNotReady:   {14, "warming up"},
+	failureSourceLost: {22, "service entry source unreachable"},
+}
+
+// setExtendedError attaches the Extended DNS Error for reason to the response
+// if the request carried an OPT record.
+func setExtendedError(request *dns.Msg, response *dns.Msg, reason failure) {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return
+	}
+	ede, found := extendedErrors[reason]
+	if !found {
+		return
+	}
+
+	data := make([]byte, 2, 2+len(ede.text))
+	binary.BigEndian.PutUint16(data, ede.code)
+	data = append(data, ede.text...)
+
+	responseOpt := response.IsEdns0()
+	if responseOpt == nil {
+		response.SetEdns0(opt.UDPSize(), opt.Do())
+		responseOpt = response.IsEdns0()
+	}
+	responseOpt.Option = append(responseOpt.Option, &dns.EDNS0_LOCAL{Code: edeOption, Data: data})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestExtendedErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(h *IstioServiceEntries)
+		edns  bool
+		rcode int
+		// code is the info code of the extended error, -1 if none
+		code int
+	}{
+		{
+			name:  "prohibited",
+			setup: func(h *IstioServiceEntries) { h.acl, _ = newACL("10.0.0.0/8", "") },
+			edns:  true,
+			rcode: dns.RcodeRefused,
+			code:  18,
+		},
+		{
+			name:  "prohibited without EDNS",
+			setup: func(h *IstioServiceEntries) { h.acl, _ = newACL("10.0.0.0/8", "") },
+			rcode: dns.RcodeRefused,
+			code:  -1,
+		},
+		{
+			name:  "not ready",
+			setup: func(h *IstioServiceEntries) { h.serveBeforeSync = false },
+			edns:  true,
+			rcode: dns.RcodeServerFailure,
+			code:  14,
+		},
+		{
+			name: "source lost",
+			setup: func(h *IstioServiceEntries) {
+				h.sourceLossCutoff = time.Minute
+				h.lastContact = time.Now().Add(-time.Hour).UnixNano()
+			},
+			edns:  true,
+			rcode: dns.RcodeServerFailure,
+			code:  22,
+		},
+		{
+			name:  "answered",
+			setup: func(h *IstioServiceEntries) {},
+			edns:  true,
+			code:  -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			tt.setup(h)
+			request := new(dns.Msg).SetQuestion("foo.com.", dns.TypeA)
+			if tt.edns {
+				request.SetEdns0(1232, false)
+			}
+			response := exchange(t, h, request)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			code := -1
+			if opt := response.IsEdns0(); opt != nil {
+				for _, o := range opt.Option {
+					if local, ok := o.(*dns.EDNS0_LOCAL); ok && local.Code == edeOption && len(local.Data) >= 2 {
+						code = int(binary.BigEndian.Uint16(local.Data))
+					}
+				}
+			}
+			if code != tt.code {
+				t.Errorf("extended error = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
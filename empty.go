@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// emptyMode controls how MESH_INTERNAL hosts without addresses and without
+// endpoints are answered.
+type emptyMode int
+
+const (
+	// emptyNXDOMAIN leaves such hosts out of the table
+	emptyNXDOMAIN emptyMode = iota
+	// emptyNODATA answers with an empty NOERROR
+	emptyNODATA
+	// emptySERVFAIL answers with SERVFAIL
+	emptySERVFAIL
+)
+
+func parseEmptyMode(mode string) (emptyMode, error) {
+	switch mode {
+	case "", "nxdomain":
+		return emptyNXDOMAIN, nil
+	case "nodata":
+		return emptyNODATA, nil
+	case "servfail":
+		return emptySERVFAIL, nil
+	}
+	return emptyNXDOMAIN, fmt.Errorf("unknown mode %q, expected nxdomain, nodata or servfail", mode)
+}
+
+// keepWithoutEndpoints reports whether the hosts of a ServiceEntry that has
+// neither addresses nor endpoints stay in the table, without records.
+func (h *IstioServiceEntries) keepWithoutEndpoints(entry *networking.ServiceEntry) bool {
+	return h.emptyInternal != emptyNXDOMAIN &&
+		entry.Location == networking.ServiceEntry_MESH_INTERNAL &&
+		len(entry.Endpoints) == 0
+}
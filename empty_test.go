@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestEmptyInternal(t *testing.T) {
+	empty := func(name string, location networking.ServiceEntry_Location) *networking.ServiceEntry {
+		return &networking.ServiceEntry{
+			Hosts:      []string{name},
+			Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+			Location:   location,
+			Resolution: networking.ServiceEntry_DNS,
+		}
+	}
+	tests := []struct {
+		mode     string
+		internal int
+		external int
+	}{
+		{mode: "nxdomain", internal: dns.RcodeNameError, external: dns.RcodeNameError},
+		{mode: "nodata", internal: dns.RcodeSuccess, external: dns.RcodeNameError},
+		{mode: "servfail", internal: dns.RcodeServerFailure, external: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			h := newStoreHandle(t,
+				serviceEntry("internal", nil, empty("internal.com", networking.ServiceEntry_MESH_INTERNAL)),
+				serviceEntry("external", nil, empty("external.com", networking.ServiceEntry_MESH_EXTERNAL)),
+			)
+			var err error
+			if h.emptyInternal, err = parseEmptyMode(tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			h.readServiceEntries("")
+			for name, want := range map[string]int{"internal.com.": tt.internal, "external.com.": tt.external} {
+				response := query(t, h, name, dns.TypeA)
+				if response.Rcode != want || len(response.Answer) != 0 {
+					t.Errorf("%s: rcode %s with %d records, want %s without records", name, dns.RcodeToString[response.Rcode], len(response.Answer), dns.RcodeToString[want])
+				}
+			}
+		})
+	}
+	if _, err := parseEmptyMode("drop"); err == nil {
+		t.Error("parseEmptyMode() accepted an unknown mode")
+	}
+}
+
+func TestEmptyInternalWildcardAddress(t *testing.T) {
+	internal := serviceEntry("internal", nil, &networking.ServiceEntry{
+		Hosts:      []string{"internal.com"},
+		Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+		Location:   networking.ServiceEntry_MESH_INTERNAL,
+		Resolution: networking.ServiceEntry_DNS,
+	})
+	wildcard := serviceEntry("wildcard", nil, &networking.ServiceEntry{
+		Hosts:      []string{"*.wild.com"},
+		Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+		Location:   networking.ServiceEntry_MESH_INTERNAL,
+		Resolution: networking.ServiceEntry_STATIC,
+		Endpoints:  []*networking.ServiceEntry_Endpoint{{Address: "192.0.2.1"}},
+	})
+	tests := []struct {
+		mode  string
+		name  string
+		rcode int
+		want  []string
+	}{
+		{mode: "nodata", name: "internal.com.", rcode: dns.RcodeSuccess},
+		{mode: "nodata", name: "foo.wild.com.", rcode: dns.RcodeSuccess, want: []string{"10.255.0.1"}},
+		{mode: "servfail", name: "internal.com.", rcode: dns.RcodeServerFailure},
+		{mode: "servfail", name: "foo.wild.com.", rcode: dns.RcodeSuccess, want: []string{"10.255.0.1"}},
+		{mode: "nxdomain", name: "internal.com.", rcode: dns.RcodeNameError},
+		{mode: "nxdomain", name: "foo.wild.com.", rcode: dns.RcodeSuccess, want: []string{"10.255.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.name, func(t *testing.T) {
+			h := newStoreHandle(t, internal, wildcard)
+			var err error
+			if h.emptyInternal, err = parseEmptyMode(tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			h.wildcardAddress = net.ParseIP("10.255.0.1")
+			h.readServiceEntries("")
+			response := query(t, h, tt.name, dns.TypeA)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
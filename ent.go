@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// emptyNonTerminals returns the names that own no records but have names
+// below them that do, e.g. foo.mesh.internal. for a static SRV record of
+// _http._tcp.foo.mesh.internal. Such names exist (RFC 8020) and are answered
+// with NODATA rather than NXDOMAIN. Only names up to the apex of the zone of
+// a name are taken, not the parents of the zone such as its TLD.
+func emptyNonTerminals(dnsEntries map[string]*dnsEntry, ptrEntries map[string][]string, static staticRecords, zones []string) map[string]bool {
+	owned := func(name string) bool {
+		_, entry := dnsEntries[name]
+		_, reverse := ptrEntries[name]
+		_, records := static[name]
+		return entry || reverse || records
+	}
+	ents := make(map[string]bool)
+	add := func(name string, self bool) {
+		start := 1
+		if self {
+			start = 0
+		}
+		apex := zoneOf(zones, name)
+		labels := dns.Split(name)
+		for _, i := range labels[start:] {
+			parent := name[i:]
+			if len(parent) < len(apex) {
+				break
+			}
+			if !owned(parent) {
+				ents[parent] = true
+			}
+		}
+	}
+	for k := range dnsEntries {
+		if strings.HasPrefix(k, ".") {
+			// the parent of the wildcard *.foo.com. is foo.com.
+			add(k[1:], true)
+		} else {
+			add(k, false)
+		}
+	}
+	for k := range ptrEntries {
+		add(k, false)
+	}
+	for k := range static {
+		add(k, false)
+	}
+	return ents
+}
+
+// nonTerminal reports whether the question is for an empty non-terminal.
+func (v tableView) nonTerminal(question []dns.Question) bool {
+	if len(question) != 1 {
+		return false
+	}
+	return v.nonTerminals[strings.ToLower(dns.Fqdn(question[0].Name))]
+}
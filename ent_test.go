@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestEmptyNonTerminals(t *testing.T) {
+	tests := []struct {
+		name       string
+		dnsEntries map[string]*dnsEntry
+		ptrEntries map[string][]string
+		zones      string
+		want       map[string]bool
+	}{
+		{
+			name:       "up to the default apex",
+			dnsEntries: map[string]*dnsEntry{"a.b.example.com.": {vips: ips("10.0.0.1")}},
+			want:       map[string]bool{"b.example.com.": true, "example.com.": true},
+		},
+		{
+			name:       "up to the configured apex",
+			dnsEntries: map[string]*dnsEntry{"a.b.svc.cluster.local.": {vips: ips("10.0.0.1")}},
+			zones:      "svc.cluster.local",
+			want:       map[string]bool{"b.svc.cluster.local.": true, "svc.cluster.local.": true},
+		},
+		{
+			name: "owned parent",
+			dnsEntries: map[string]*dnsEntry{
+				"a.b.example.com.": {vips: ips("10.0.0.1")},
+				"b.example.com.":   {vips: ips("10.0.0.2")},
+			},
+			want: map[string]bool{"example.com.": true},
+		},
+		{
+			name:       "wildcard parent",
+			dnsEntries: map[string]*dnsEntry{".wild.example.com.": {vips: ips("10.0.0.1")}},
+			want:       map[string]bool{"wild.example.com.": true, "example.com.": true},
+		},
+		{
+			name:       "reverse",
+			ptrEntries: map[string][]string{"1.0.0.10.in-addr.arpa.": {"foo.com."}},
+			want:       map[string]bool{"0.0.10.in-addr.arpa.": true, "0.10.in-addr.arpa.": true, "10.in-addr.arpa.": true, "in-addr.arpa.": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := emptyNonTerminals(tt.dnsEntries, tt.ptrEntries, nil, parseZones(tt.zones)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("emptyNonTerminals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNonTerminalAnswers(t *testing.T) {
+	dnsEntries := map[string]*dnsEntry{"a.b.example.com.": {vips: ips("10.0.0.1")}}
+	h := newTestHandle(dnsEntries, nil)
+	h.nonTerminals = emptyNonTerminals(dnsEntries, nil, nil, parseZones(""))
+	tests := []struct {
+		qname string
+		rcode int
+	}{
+		{qname: "b.example.com.", rcode: dns.RcodeSuccess},
+		{qname: "B.Example.com.", rcode: dns.RcodeSuccess},
+		{qname: "example.com.", rcode: dns.RcodeSuccess},
+		{qname: "com.", rcode: dns.RcodeNameError},
+		{qname: "c.example.com.", rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qname, func(t *testing.T) {
+			response := query(t, h, tt.qname, dns.TypeA)
+			if response.Rcode != tt.rcode || len(response.Answer) != 0 {
+				t.Errorf("rcode = %s, answer = %v, want %s without records", dns.RcodeToString[response.Rcode], response.Answer, dns.RcodeToString[tt.rcode])
+			}
+		})
+	}
+}
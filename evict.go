@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// hostOrigin records where a table entry came from, for eviction.
+type hostOrigin struct {
+	location networking.ServiceEntry_Location
+	created  time.Time
+	// removed marks a host retained after its removal, evicted first
+	removed bool
+}
+
+// evictHosts drops entries from dnsEntries until at most maxHosts remain.
+// Hosts retained after their removal go first, then entries of MESH_EXTERNAL
+// service entries before MESH_INTERNAL ones and older service entries before
+// newer ones. Entries without an origin, such as Kubernetes Services, go last.
+// It returns the number of evicted entries.
+func evictHosts(dnsEntries map[string]*dnsEntry, origins map[string]hostOrigin, maxHosts int) int {
+	if maxHosts <= 0 || len(dnsEntries) <= maxHosts {
+		return 0
+	}
+
+	keys := make([]string, 0, len(dnsEntries))
+	for k := range dnsEntries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, aFound := origins[keys[i]]
+		b, bFound := origins[keys[j]]
+		if aFound != bFound {
+			return aFound
+		}
+		if a.removed != b.removed {
+			return a.removed
+		}
+		if aFound {
+			if a.location != b.location {
+				return a.location == networking.ServiceEntry_MESH_EXTERNAL
+			}
+			if !a.created.Equal(b.created) {
+				return a.created.Before(b.created)
+			}
+		}
+		return keys[i] < keys[j]
+	})
+
+	evict := keys[:len(keys)-maxHosts]
+	log.Printf("DNS table has %d hosts, more than %d: evicting %v\n", len(dnsEntries), maxHosts, evict)
+	for _, k := range evict {
+		delete(dnsEntries, k)
+	}
+	return len(evict)
+}
+
+// prunePTREntries drops names from ptrEntries that are no longer in
+// dnsEntries.
+func prunePTREntries(ptrEntries map[string][]string, dnsEntries map[string]*dnsEntry) {
+	for name, hosts := range ptrEntries {
+		kept := hosts[:0]
+		for _, host := range hosts {
+			if _, found := dnsEntries[host]; found {
+				kept = append(kept, host)
+			}
+		}
+		if len(kept) == 0 {
+			delete(ptrEntries, name)
+		} else {
+			ptrEntries[name] = kept
+		}
+	}
+}
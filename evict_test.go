@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+func TestEvictHosts(t *testing.T) {
+	old := time.Unix(1000, 0)
+	recent := time.Unix(2000, 0)
+	origins := map[string]hostOrigin{
+		"removed.com.":      {removed: true},
+		"old-external.com.": {location: networking.ServiceEntry_MESH_EXTERNAL, created: old},
+		"new-external.com.": {location: networking.ServiceEntry_MESH_EXTERNAL, created: recent},
+		"old-internal.com.": {location: networking.ServiceEntry_MESH_INTERNAL, created: old},
+		"new-internal.com.": {location: networking.ServiceEntry_MESH_INTERNAL, created: recent},
+	}
+	tests := []struct {
+		name     string
+		maxHosts int
+		want     []string
+	}{
+		{name: "unbounded", want: []string{"kube.svc.", "new-external.com.", "new-internal.com.", "old-external.com.", "old-internal.com.", "removed.com."}},
+		{name: "within bound", maxHosts: 6, want: []string{"kube.svc.", "new-external.com.", "new-internal.com.", "old-external.com.", "old-internal.com.", "removed.com."}},
+		{name: "removed first", maxHosts: 5, want: []string{"kube.svc.", "new-external.com.", "new-internal.com.", "old-external.com.", "old-internal.com."}},
+		{name: "older external next", maxHosts: 4, want: []string{"kube.svc.", "new-external.com.", "new-internal.com.", "old-internal.com."}},
+		{name: "external before internal", maxHosts: 2, want: []string{"kube.svc.", "new-internal.com."}},
+		{name: "without origin last", maxHosts: 1, want: []string{"kube.svc."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dnsEntries := map[string]*dnsEntry{"kube.svc.": {}}
+			for k := range origins {
+				dnsEntries[k] = &dnsEntry{}
+			}
+			evicted := evictHosts(dnsEntries, origins, tt.maxHosts)
+			var got []string
+			for k := range dnsEntries {
+				got = append(got, k)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+			if want := len(origins) + 1 - len(tt.want); evicted != want {
+				t.Errorf("evicted %d, want %d", evicted, want)
+			}
+		})
+	}
+}
+
+func TestPrunePTREntries(t *testing.T) {
+	ptrEntries := map[string][]string{
+		"1.0.0.10.in-addr.arpa.": {"kept.com.", "evicted.com."},
+		"2.0.0.10.in-addr.arpa.": {"evicted.com."},
+	}
+	prunePTREntries(ptrEntries, map[string]*dnsEntry{"kept.com.": {}})
+	want := map[string][]string{"1.0.0.10.in-addr.arpa.": {"kept.com."}}
+	if !reflect.DeepEqual(ptrEntries, want) {
+		t.Errorf("reverse entries = %v, want %v", ptrEntries, want)
+	}
+}
+
+func TestEvictedHostsGauge(t *testing.T) {
+	var configs []model.Config
+	for _, host := range []string{"a.com", "b.com", "c.com"} {
+		configs = append(configs, serviceEntry(strings.TrimSuffix(host, ".com"), nil, &networking.ServiceEntry{
+			Hosts: []string{host}, Addresses: []string{"10.0.0.1"},
+			Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+			Resolution: networking.ServiceEntry_STATIC, Endpoints: []*networking.ServiceEntry_Endpoint{{Address: "192.0.2.1"}},
+		}))
+	}
+	h := newStoreHandle(t, configs...)
+	h.maxHosts = 2
+	// the same host is evicted on every read, and counted once
+	for read := 0; read < 3; read++ {
+		h.readServiceEntries("")
+		if got := metricValue(t, evictedHosts); got != 1 {
+			t.Fatalf("read %d: evicted hosts = %v, want 1", read, got)
+		}
+	}
+	h.maxHosts = 0
+	h.readServiceEntries("")
+	if got := metricValue(t, evictedHosts); got != 0 {
+		t.Errorf("evicted hosts = %v, want 0", got)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// exportVersion is the version of the /admin/export format. /admin/import
+// rejects any other version.
+const exportVersion = 1
+
+// maxImportSize bounds the body of /admin/import, which is decoded in
+// memory; tables of tens of thousands of hosts fit.
+const maxImportSize = 8 << 20
+
+// tableExport is the state of the server as /admin/export renders it.
+type tableExport struct {
+	Version int         `json:"version"`
+	Serial  uint32      `json:"serial"`
+	Hosts   []exportRow `json:"hosts"`
+	// Reverse maps reverse names to the hosts declaring their address
+	Reverse map[string][]string `json:"reverse,omitempty"`
+}
+
+// exportRow is one host of the DNS table with all of its state.
+type exportRow struct {
+	tableRow
+	Chase          bool                `json:"chase,omitempty"`
+	TTL            uint32              `json:"ttl,omitempty"`
+	HasSubsets     bool                `json:"hasSubsets,omitempty"`
+	SubsetOf       string              `json:"subsetOf,omitempty"`
+	MaxAnswers     int                 `json:"maxAnswers,omitempty"`
+	Stable         bool                `json:"stable,omitempty"`
+	Ordered        bool                `json:"ordered,omitempty"`
+	Decommissioned bool                `json:"decommissioned,omitempty"`
+	NoEndpoints    bool                `json:"noEndpoints,omitempty"`
+	Ports          map[uint32][]string `json:"ports,omitempty"`
+	Tenants        map[string][]string `json:"tenants,omitempty"`
+	ExportTo       []string            `json:"exportTo,omitempty"`
+}
+
+// adminExport renders the DNS table, reverse table and serial as one JSON
+// document, for /admin/import on the instance replacing this one.
+func (h *IstioServiceEntries) adminExport(w http.ResponseWriter, r *http.Request) {
+	v := h.view()
+	export := tableExport{Version: exportVersion, Serial: v.serial, Hosts: make([]exportRow, 0, len(v.dnsEntries)), Reverse: v.ptrEntries}
+	for key, e := range v.dnsEntries {
+		row := exportRow{
+			tableRow:       tableRow{Host: key, Addresses: ipStrings(e.vips), CNAME: e.cname},
+			Chase:          e.chase,
+			TTL:            e.ttl,
+			HasSubsets:     e.hasSubsets,
+			SubsetOf:       e.subsetOf,
+			MaxAnswers:     e.maxAnswers,
+			Stable:         e.stable,
+			Ordered:        e.ordered,
+			Decommissioned: e.decommissioned,
+			NoEndpoints:    e.noEndpoints,
+			ExportTo:       e.exportTo,
+		}
+		if e.ports != nil {
+			row.Ports = make(map[uint32][]string, len(e.ports))
+			for port, ips := range e.ports {
+				row.Ports[port] = ipStrings(ips)
+			}
+		}
+		if e.tenants != nil {
+			row.Tenants = make(map[string][]string, len(e.tenants))
+			for tenant, ips := range e.tenants {
+				row.Tenants[tenant] = ipStrings(ips)
+			}
+		}
+		export.Hosts = append(export.Hosts, row)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(export); err != nil {
+		log.Printf("Failed to write export: %v\n", err)
+	}
+}
+
+// adminImport loads the state rendered by /admin/export of another instance.
+// It is only accepted before the service entries are synced; the imported
+// table is served until then, with --serve-before-sync, and its serial
+// carried on.
+func (h *IstioServiceEntries) adminImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var export tableExport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&export); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse export: %v", err), http.StatusBadRequest)
+		return
+	}
+	dnsEntries, ptrEntries, err := importTable(export)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.mapMutex.Lock()
+	if h.importClosed {
+		h.mapMutex.Unlock()
+		http.Error(w, "service entries already synced", http.StatusConflict)
+		return
+	}
+	h.dnsEntries = dnsEntries
+	h.ptrEntries = ptrEntries
+	h.serial = export.Serial
+	h.resetCaches()
+	if h.emptyNonTerminals {
+		h.nonTerminals = emptyNonTerminals(dnsEntries, ptrEntries, h.static, h.zones)
+	}
+	atomic.StoreInt32(&h.imported, 1)
+	h.mapMutex.Unlock()
+	log.Printf("Imported %d hosts at serial %d\n", len(dnsEntries), export.Serial)
+	fmt.Fprintln(w, "ok")
+}
+
+// importTable validates an export and rebuilds the tables it holds.
+func importTable(export tableExport) (map[string]*dnsEntry, map[string][]string, error) {
+	if export.Version != exportVersion {
+		return nil, nil, fmt.Errorf("unsupported export version %d, expected %d", export.Version, exportVersion)
+	}
+	dnsEntries := make(map[string]*dnsEntry, len(export.Hosts))
+	for _, row := range export.Hosts {
+		if row.Host == "" || row.Host != strings.ToLower(dns.Fqdn(row.Host)) {
+			return nil, nil, fmt.Errorf("invalid host %q", row.Host)
+		}
+		vips, err := parseIPs(row.Host, row.Addresses)
+		if err != nil {
+			return nil, nil, err
+		}
+		e := &dnsEntry{
+			vips:           vips,
+			cname:          row.CNAME,
+			chase:          row.Chase,
+			ttl:            row.TTL,
+			hasSubsets:     row.HasSubsets,
+			subsetOf:       row.SubsetOf,
+			maxAnswers:     row.MaxAnswers,
+			stable:         row.Stable,
+			ordered:        row.Ordered,
+			decommissioned: row.Decommissioned,
+			noEndpoints:    row.NoEndpoints,
+		}
+		if row.Ports != nil {
+			e.ports = make(map[uint32][]net.IP, len(row.Ports))
+			for port, addresses := range row.Ports {
+				if e.ports[port], err = parseIPs(row.Host, addresses); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		if row.Tenants != nil {
+			e.tenants = make(map[string][]net.IP, len(row.Tenants))
+			for tenant, addresses := range row.Tenants {
+				if e.tenants[tenant], err = parseIPs(row.Host, addresses); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		if len(row.ExportTo) > 0 {
+			e.exportTo = row.ExportTo
+		}
+		e.portEntries = portEntries(e)
+		dnsEntries[row.Host] = e
+	}
+	ptrEntries := export.Reverse
+	if ptrEntries == nil {
+		ptrEntries = make(map[string][]string)
+	}
+	return dnsEntries, ptrEntries, nil
+}
+
+// parseIPs parses the addresses of host.
+func parseIPs(host string, addresses []string) ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q of %s", address, host)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
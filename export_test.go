@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// adminRequest sends a request to the admin handler of h and returns the
+// response.
+func adminRequest(h *IstioServiceEntries, enableImport bool, method, target string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.adminHandler(false, enableImport).ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewReader(body)))
+	return w
+}
+
+func TestExportImport(t *testing.T) {
+	foo := &dnsEntry{vips: ips("10.0.0.1", "10.0.0.2"), ttl: 60, maxAnswers: 1, ports: map[uint32][]net.IP{80: ips("192.0.2.1")}}
+	foo.portEntries = portEntries(foo)
+	source := newTestHandle(map[string]*dnsEntry{
+		"foo.com.":   foo,
+		"bar.com.":   {cname: "foo.com."},
+		"old.com.":   {decommissioned: true},
+		".wild.com.": {vips: ips("10.0.0.3")},
+	}, map[string][]string{"1.0.0.10.in-addr.arpa.": {"foo.com."}})
+	source.serial = 42
+	export := adminRequest(source, false, http.MethodGet, "/admin/export", nil)
+	if export.Code != http.StatusOK {
+		t.Fatalf("export status = %d: %s", export.Code, export.Body)
+	}
+
+	h := newTestHandle(nil, nil)
+	h.portLabels = true
+	if w := adminRequest(h, true, http.MethodPost, "/admin/import", export.Body.Bytes()); w.Code != http.StatusOK {
+		t.Fatalf("import status = %d: %s", w.Code, w.Body)
+	}
+	if h.serial != 42 {
+		t.Errorf("serial = %d, want 42", h.serial)
+	}
+	tests := []struct {
+		qname string
+		qtype uint16
+		rcode int
+		want  []string
+	}{
+		{qname: "foo.com.", qtype: dns.TypeA, want: []string{"10.0.0.1"}},
+		{qname: "foo.com.", qtype: dns.TypeA, want: []string{"10.0.0.2"}},
+		{qname: "_80.foo.com.", qtype: dns.TypeA, want: []string{"192.0.2.1"}},
+		{qname: "bar.com.", qtype: dns.TypeA, want: []string{"foo.com."}},
+		{qname: "old.com.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{qname: "a.wild.com.", qtype: dns.TypeA, want: []string{"10.0.0.3"}},
+		{qname: "1.0.0.10.in-addr.arpa.", qtype: dns.TypePTR, want: []string{"foo.com."}},
+	}
+	for _, tt := range tests {
+		response := query(t, h, tt.qname, tt.qtype)
+		if response.Rcode != tt.rcode {
+			t.Errorf("%s: rcode = %s, want %s", tt.qname, dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+		}
+		if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: answer = %v, want %v", tt.qname, got, tt.want)
+		}
+	}
+
+	if answer := query(t, h, "foo.com.", dns.TypeA).Answer; len(answer) != 1 || answer[0].Header().Ttl != 60 {
+		t.Errorf("answer = %v, want one record with a TTL of 60", answer)
+	}
+
+	// an imported table is not served before the sync by default
+	h.serveBeforeSync = false
+	if response := query(t, h, "foo.com.", dns.TypeA); response.Rcode != dns.RcodeServerFailure {
+		t.Errorf("rcode = %s, want SERVFAIL", dns.RcodeToString[response.Rcode])
+	}
+}
+
+func TestAdminImport(t *testing.T) {
+	tests := []struct {
+		name         string
+		enableImport bool
+		closed       bool
+		method       string
+		body         string
+		status       int
+	}{
+		{name: "disabled", method: http.MethodPost, body: `{"version":1}`, status: http.StatusNotFound},
+		{name: "get", enableImport: true, method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{name: "malformed", enableImport: true, method: http.MethodPost, body: `{`, status: http.StatusBadRequest},
+		{name: "other version", enableImport: true, method: http.MethodPost, body: `{"version":2}`, status: http.StatusBadRequest},
+		{name: "relative host", enableImport: true, method: http.MethodPost, body: `{"version":1,"hosts":[{"host":"foo.com"}]}`, status: http.StatusBadRequest},
+		{name: "upper case host", enableImport: true, method: http.MethodPost, body: `{"version":1,"hosts":[{"host":"Foo.com."}]}`, status: http.StatusBadRequest},
+		{name: "invalid address", enableImport: true, method: http.MethodPost, body: `{"version":1,"hosts":[{"host":"foo.com.","addresses":["nope"]}]}`, status: http.StatusBadRequest},
+		{name: "invalid port address", enableImport: true, method: http.MethodPost, body: `{"version":1,"hosts":[{"host":"foo.com.","ports":{"80":["nope"]}}]}`, status: http.StatusBadRequest},
+		{name: "after the sync", enableImport: true, closed: true, method: http.MethodPost, body: `{"version":1}`, status: http.StatusConflict},
+		{name: "imported", enableImport: true, method: http.MethodPost, body: `{"version":1,"serial":3,"hosts":[{"host":"foo.com.","addresses":["10.0.0.1"]}]}`, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"bar.com.": {vips: ips("10.0.0.2")}}, nil)
+			h.importClosed = tt.closed
+			w := adminRequest(h, tt.enableImport, tt.method, "/admin/import", []byte(tt.body))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			// the table is only replaced by a successful import
+			_, replaced := h.dnsEntries["foo.com."]
+			if imported := h.imported != 0; replaced != imported || imported != (tt.status == http.StatusOK) {
+				t.Errorf("table replaced %v, imported %v", replaced, imported)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// entryExportTo returns the namespaces the exportTo of a ServiceEntry in
+// namespace exports it to, sorted, or nil if it is exported to all of them.
+func entryExportTo(namespace string, exportTo []string) []string {
+	if len(exportTo) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(exportTo))
+	namespaces := make([]string, 0, len(exportTo))
+	for _, ns := range exportTo {
+		switch ns {
+		case "*":
+			return nil
+		case ".":
+			ns = namespace
+		}
+		if !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// exportedTo reports whether the entry is served to workloads in namespace.
+func (e *dnsEntry) exportedTo(namespace string) bool {
+	if e.exportTo == nil {
+		return true
+	}
+	for _, ns := range e.exportTo {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// sameNamespaces reports whether two exportTo lists are the same.
+func sameNamespaces(a, b []string) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// requestNamespace returns the namespace of the querying workload carried by
+// the request in the EDNS0 local option code, if any.
+func requestNamespace(request *dns.Msg, code uint16) (string, bool) {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return "", false
+	}
+	for _, o := range opt.Option {
+		if local, ok := o.(*dns.EDNS0_LOCAL); ok && local.Code == code {
+			return string(local.Data), true
+		}
+	}
+	return "", false
+}
+
+// visible reports whether the entry is served for the request: always,
+// unless --namespace-option is set and the request names a namespace the
+// entry is not exported to.
+func (h *IstioServiceEntries) visible(request *dns.Msg, entry *dnsEntry) bool {
+	if h.namespaceOption == 0 {
+		return true
+	}
+	namespace, found := requestNamespace(request, h.namespaceOption)
+	return !found || entry.exportedTo(namespace)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestEntryExportTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		exportTo []string
+		want     []string
+	}{
+		{name: "unset"},
+		{name: "all", exportTo: []string{"*"}},
+		{name: "all among others", exportTo: []string{"b", "*"}},
+		{name: "own namespace", exportTo: []string{"."}, want: []string{"team-a"}},
+		{name: "named", exportTo: []string{"b", ".", "a", "b"}, want: []string{"a", "b", "team-a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entryExportTo("team-a", tt.exportTo); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entryExportTo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportToAnswers(t *testing.T) {
+	const namespaceOption = 65003
+	entry := func(name string, exportTo ...string) *networking.ServiceEntry {
+		return &networking.ServiceEntry{
+			Hosts:      []string{name},
+			Addresses:  []string{"10.0.0.1"},
+			Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+			Resolution: networking.ServiceEntry_DNS,
+			ExportTo:   exportTo,
+		}
+	}
+	private := serviceEntry("private", nil, entry("private.com", "."))
+	private.Namespace = "team-a"
+	shared := serviceEntry("shared", nil, entry("shared.com", ".", "team-b"))
+	shared.Namespace = "team-a"
+	public := serviceEntry("public", nil, entry("public.com", "*"))
+	public.Namespace = "team-a"
+	tests := []struct {
+		name      string
+		option    uint16
+		qname     string
+		namespace string
+		tagged    bool
+		rcode     int
+	}{
+		{name: "own namespace", option: namespaceOption, qname: "private.com.", namespace: "team-a", tagged: true},
+		{name: "other namespace", option: namespaceOption, qname: "private.com.", namespace: "team-b", tagged: true, rcode: dns.RcodeNameError},
+		{name: "empty namespace", option: namespaceOption, qname: "private.com.", tagged: true, rcode: dns.RcodeNameError},
+		{name: "untagged", option: namespaceOption, qname: "private.com."},
+		{name: "named namespace", option: namespaceOption, qname: "shared.com.", namespace: "team-b", tagged: true},
+		{name: "unnamed namespace", option: namespaceOption, qname: "shared.com.", namespace: "team-c", tagged: true, rcode: dns.RcodeNameError},
+		{name: "exported to all", option: namespaceOption, qname: "public.com.", namespace: "team-c", tagged: true},
+		{name: "disabled", qname: "private.com.", namespace: "team-b", tagged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newStoreHandle(t, private, shared, public)
+			h.namespaceOption = tt.option
+			h.readServiceEntries("")
+			request := new(dns.Msg).SetQuestion(tt.qname, dns.TypeA)
+			if tt.tagged {
+				request.SetEdns0(1232, false)
+				opt := request.IsEdns0()
+				opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: namespaceOption, Data: []byte(tt.namespace)})
+			}
+			// asked twice, so that cached answers are scoped too
+			for i := 0; i < 2; i++ {
+				response := exchange(t, h, request)
+				if response.Rcode != tt.rcode {
+					t.Fatalf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+				}
+				want := []string{"10.0.0.1"}
+				if tt.rcode != dns.RcodeSuccess {
+					want = nil
+				}
+				if got := rdata(response.Answer); !reflect.DeepEqual(got, want) {
+					t.Errorf("answer = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// loadTable reads a DNS table in the newline delimited JSON format of
+// /debug/table and returns its entries and reverse entries.
+func loadTable(path string) (map[string]*dnsEntry, map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	dnsEntries := make(map[string]*dnsEntry)
+	ptrEntries := make(map[string][]string)
+	dec := json.NewDecoder(f)
+	for {
+		var row tableRow
+		if err := dec.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if row.Host == "" {
+			return nil, nil, fmt.Errorf("failed to parse %s: row without host", path)
+		}
+		key := strings.ToLower(dns.Fqdn(row.Host))
+		entry := &dnsEntry{cname: row.CNAME, vips: make([]net.IP, 0, len(row.Addresses))}
+		for _, address := range row.Addresses {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return nil, nil, fmt.Errorf("failed to parse %s: invalid address %q of %s", path, address, row.Host)
+			}
+			entry.vips = append(entry.vips, ip)
+		}
+		if entry.cname != "" {
+			entry.cname = dns.Fqdn(entry.cname)
+		}
+		dnsEntries[key] = entry
+		if !strings.HasPrefix(key, ".") {
+			addReverseEntries(ptrEntries, key, entry.vips)
+		}
+	}
+	for _, names := range ptrEntries {
+		sort.Strings(names)
+	}
+	return dnsEntries, ptrEntries, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// writeTable writes content to a file in a new temporary directory and
+// returns its path.
+func writeTable(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "table.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		reverse map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "table",
+			content: `{"host":"Foo.com","addresses":["10.0.0.1"]}
+{"host":"bar.com.","addresses":["10.0.0.1"]}
+{"host":".wild.com.","addresses":["10.0.0.2"]}
+{"host":"alias.com.","cname":"foo.com"}
+`,
+			want:    map[string]string{"foo.com.": "[10.0.0.1]", "bar.com.": "[10.0.0.1]", ".wild.com.": "[10.0.0.2]", "alias.com.": "CNAME foo.com."},
+			reverse: map[string][]string{"1.0.0.10.in-addr.arpa.": {"bar.com.", "foo.com."}},
+		},
+		{name: "empty", want: map[string]string{}, reverse: map[string][]string{}},
+		{name: "no host", content: `{"addresses":["10.0.0.1"]}`, wantErr: true},
+		{name: "bad address", content: `{"host":"foo.com.","addresses":["10.0.0"]}`, wantErr: true},
+		{name: "not json", content: `foo.com. 10.0.0.1`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dnsEntries, ptrEntries, err := loadTable(writeTable(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadTable() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := make(map[string]string, len(dnsEntries))
+			for k, v := range dnsEntries {
+				got[k] = v.String()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(ptrEntries, tt.reverse) {
+				t.Errorf("reverse entries = %v, want %v", ptrEntries, tt.reverse)
+			}
+		})
+	}
+}
+
+func TestFallbackTable(t *testing.T) {
+	h := newStoreHandle(t, serviceEntry("foo", nil, &networking.ServiceEntry{
+		Hosts: []string{"foo.com"}, Addresses: []string{"10.0.0.2"},
+		Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+		Resolution: networking.ServiceEntry_STATIC, Endpoints: []*networking.ServiceEntry_Endpoint{{Address: "192.0.2.1"}},
+	}))
+	var err error
+	if h.dnsEntries, h.ptrEntries, err = loadTable(writeTable(t, `{"host":"foo.com.","addresses":["10.0.0.1"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	h.fallback = true
+	synced := false
+	h.synced = func() bool { return synced }
+
+	h.readServiceEntries("")
+	if got := rdata(query(t, h, "foo.com.", dns.TypeA).Answer); !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+		t.Errorf("before the sync: answer = %v, want the fallback table", got)
+	}
+	synced = true
+	h.readServiceEntries("")
+	if got := rdata(query(t, h, "foo.com.", dns.TypeA).Answer); !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("after the sync: answer = %v, want the service entries", got)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	evictedHosts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "istio_coredns_evicted_hosts",
+		Help: "Number of hosts dropped from the current DNS table because it exceeded --max-hosts.",
+	})
+	answerRecords = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "istio_coredns_answer_records",
+		Help:    "Number of records in the answer section of responses.",
+		Buckets: []float64{0, 1, 2, 4, 8, 16, 32, 64},
+	})
+	answerTypes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "istio_coredns_responses_by_type_total",
+		Help: "Number of responses with records of each type in the answer section.",
+	}, []string{"type"})
+	cnameChainExceeded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "istio_coredns_cname_chain_exceeded_total",
+		Help: "Number of CNAME chases that failed because the chain exceeded --max-cname-chain.",
+	})
+	duplicateEndpoints = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "istio_coredns_duplicate_endpoints",
+		Help: "Number of repeated addresses dropped while building the current DNS table.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(evictedHosts, answerRecords, answerTypes, duplicateEndpoints, cnameChainExceeded)
+}
+
+// observeAnswer records the composition of the answer section of response.
+func observeAnswer(response *dns.Msg) {
+	answerRecords.Observe(float64(len(response.Answer)))
+	seen := make(map[uint16]bool)
+	for _, rr := range response.Answer {
+		rrtype := rr.Header().Rrtype
+		if seen[rrtype] {
+			continue
+		}
+		seen[rrtype] = true
+		answerTypes.WithLabelValues(dns.TypeToString[rrtype]).Inc()
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricValue returns the current value of a counter or gauge.
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	if out.Gauge != nil {
+		return out.Gauge.GetValue()
+	}
+	return out.Counter.GetValue()
+}
+
+func TestObserveAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer []dns.RR
+		// want is the increase of the response count of each type
+		want map[string]float64
+	}{
+		{name: "empty", want: map[string]float64{"A": 0, "AAAA": 0, "CNAME": 0}},
+		{name: "addresses", answer: addressRecords("foo.com.", dns.TypeANY, ips("10.0.0.1", "10.0.0.2", "2001:db8::1"), 60), want: map[string]float64{"A": 1, "AAAA": 1, "CNAME": 0}},
+		{name: "cname", answer: append(cname("foo.com.", "bar.com.", 60), a("bar.com.", ips("10.0.0.1"), 60)...), want: map[string]float64{"A": 1, "AAAA": 0, "CNAME": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := make(map[string]float64)
+			for typ := range tt.want {
+				before[typ] = metricValue(t, answerTypes.WithLabelValues(typ))
+			}
+			observeAnswer(&dns.Msg{Answer: tt.answer})
+			for typ, want := range tt.want {
+				if got := metricValue(t, answerTypes.WithLabelValues(typ)) - before[typ]; got != want {
+					t.Errorf("%s responses increased by %v, want %v", typ, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	dnsapi "github.com/istio-ecosystem/istio-coredns-plugin/api"
+	"github.com/miekg/dns"
+)
+
+// reply compresses and pads the response, if configured, and marshals it
+// into the gRPC reply. Compression is decided first so that padding, and any
+// size check, sees the length actually sent.
+func (h *IstioServiceEntries) reply(request *dns.Msg, response *dns.Msg) (*dnsapi.DnsPacket, error) {
+	response.Compress = h.compressAbove > 0 && response.Len() > h.compressAbove
+	if h.paddingBlock > 0 {
+		pad(request, response, h.paddingBlock)
+	}
+	return pack(response)
+}
+
+// pad adds an EDNS0 padding option (RFC 7830) to the response so that its
+// packed size is a multiple of block. Only responses to requests carrying an
+// OPT record are padded.
+func pad(request *dns.Msg, response *dns.Msg, block int) {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return
+	}
+	responseOpt := response.IsEdns0()
+	if responseOpt == nil {
+		response.SetEdns0(opt.UDPSize(), opt.Do())
+		responseOpt = response.IsEdns0()
+	}
+	padding := &dns.EDNS0_PADDING{}
+	responseOpt.Option = append(responseOpt.Option, padding)
+
+	out, err := response.Pack()
+	if err != nil {
+		// pack reports the error
+		return
+	}
+	if rest := len(out) % block; rest > 0 {
+		padding.Padding = make([]byte, block-rest)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	dnsapi "github.com/istio-ecosystem/istio-coredns-plugin/api"
+)
+
+// packedReply returns the packed reply of h to request.
+func packedReply(t *testing.T, h *IstioServiceEntries, request *dns.Msg) []byte {
+	t.Helper()
+	in, err := request.Pack()
+	if err != nil {
+		t.Fatalf("failed to pack request: %v", err)
+	}
+	out, err := h.Query(context.Background(), &dnsapi.DnsPacket{Msg: in})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	return out.Msg
+}
+
+func TestPadding(t *testing.T) {
+	tests := []struct {
+		name  string
+		block int
+		edns  bool
+		// wantPadded reports whether the response carries a padding option
+		wantPadded bool
+	}{
+		{name: "disabled", edns: true},
+		{name: "without EDNS", block: 128},
+		{name: "padded", block: 128, edns: true, wantPadded: true},
+		{name: "small block", block: 16, edns: true, wantPadded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1", "10.0.0.2")}}, nil)
+			h.paddingBlock = tt.block
+			request := new(dns.Msg).SetQuestion("foo.com.", dns.TypeA)
+			if tt.edns {
+				request.SetEdns0(1232, false)
+			}
+			out := packedReply(t, h, request)
+			response := new(dns.Msg)
+			if err := response.Unpack(out); err != nil {
+				t.Fatal(err)
+			}
+			padded := false
+			if opt := response.IsEdns0(); opt != nil {
+				for _, o := range opt.Option {
+					if _, ok := o.(*dns.EDNS0_PADDING); ok {
+						padded = true
+					}
+				}
+			}
+			if padded != tt.wantPadded {
+				t.Fatalf("padded = %v, want %v", padded, tt.wantPadded)
+			}
+			if padded && len(out)%tt.block != 0 {
+				t.Errorf("response of %d bytes is not a multiple of %d", len(out), tt.block)
+			}
+			if len(response.Answer) != 2 {
+				t.Errorf("answer = %v, want 2 records", response.Answer)
+			}
+		})
+	}
+}
+
+func TestCompressAbove(t *testing.T) {
+	vips := ips("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7", "10.0.0.8")
+	request := new(dns.Msg).SetQuestion("foo.example.com.", dns.TypeA)
+	uncompressed := len(packedReply(t, newTestHandle(map[string]*dnsEntry{"foo.example.com.": {vips: vips}}, nil), request))
+	tests := []struct {
+		name          string
+		compressAbove int
+		compressed    bool
+	}{
+		{name: "disabled"},
+		{name: "above the threshold", compressAbove: 100, compressed: true},
+		{name: "below the threshold", compressAbove: 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.example.com.": {vips: vips}}, nil)
+			h.compressAbove = tt.compressAbove
+			out := packedReply(t, h, request)
+			if compressed := len(out) < uncompressed; compressed != tt.compressed {
+				t.Errorf("response of %d bytes (%d uncompressed), want compressed %v", len(out), uncompressed, tt.compressed)
+			}
+			response := new(dns.Msg)
+			if err := response.Unpack(out); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Answer) != len(vips) {
+				t.Errorf("answer = %v, want %d records", response.Answer, len(vips))
+			}
+		})
+	}
+}
@@ -5,9 +5,17 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	dnsapi "github.com/istio-ecosystem/istio-coredns-plugin/api"
@@ -19,13 +27,252 @@ import (
 	"istio.io/istio/pilot/pkg/serviceregistry/kube"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
 type IstioServiceEntries struct {
 	configStore model.IstioConfigStore
 	mapMutex    sync.RWMutex
 	stop        chan struct{}
-	dnsEntries  map[string][]net.IP
+	dnsEntries  map[string]*dnsEntry
+	// ptrEntries maps reverse names (x.x.x.x.in-addr.arpa.) of explicit
+	// ServiceEntry addresses to the hosts declaring them
+	ptrEntries map[string][]string
+	// serial counts the reads that changed the table
+	serial   uint32
+	sidecar  *sidecarScope
+	services *serviceSource
+	acl      *acl
+	// cnameMode is the default CNAME mode of DNS resolution service entries
+	cnameMode cnameMode
+	// reserved holds names never answered from the table
+	reserved reservedNames
+	// specialNames enables answering RFC 6761 special-use names
+	specialNames bool
+	// drainTTL is the TTL of hosts annotated as draining
+	drainTTL uint32
+	// decommissionTTL is the negative TTL of decommissioned hosts
+	decommissionTTL uint32
+	// nameserver is the name of the nameserver in exported zones
+	nameserver string
+	// zones are the apexes of the zones the table is served as
+	zones []string
+	// nameserverIPs answer address queries for the nameserver name
+	nameserverIPs []net.IP
+	// subsets enables serving DestinationRule subsets as <subset>.<host>
+	subsets bool
+	// wildcardAddress answers wildcard hosts without any address
+	wildcardAddress net.IP
+	// rfc6724 orders addresses per RFC 6724 relative to the client address
+	rfc6724 bool
+	// familyOrder arranges IPv4 and IPv6 addresses after the other ordering
+	familyOrder familyOrder
+	// qtypes holds the query types answered; nil answers every implemented type
+	qtypes map[uint16]bool
+	// removalGrace is how long hosts missing from a read stay resolvable
+	removalGrace time.Duration
+	// removedAt records when each host still in the grace period went missing
+	removedAt map[string]time.Time
+	// lastHeartbeat is the time, in Unix nanoseconds, of the last completed
+	// read by the watcher; accessed atomically
+	lastHeartbeat int64
+	// watchdogTimeout is how long the watcher may go without a read before
+	// it is reported as dead
+	watchdogTimeout time.Duration
+	// sourceProbe checks that the service entry source is reachable
+	sourceProbe func() error
+	// lastContact is the time, in Unix nanoseconds, sourceProbe last
+	// succeeded; accessed atomically
+	lastContact int64
+	// sourceLossCutoff is how long the source may be unreachable before
+	// queries fail
+	sourceLossCutoff time.Duration
+	// minTTL is the lowest TTL of positive answers
+	minTTL uint32
+	// ttlJitter is the percentage by which answer TTLs are spread around
+	// defaultTTL
+	ttlJitter int
+	// typeTTLs overrides the TTL base of answers by record type
+	typeTTLs map[uint16]uint32
+	// changeTTL is the TTL of hosts that changed within changeWindow
+	changeTTL    uint32
+	changeWindow time.Duration
+	// maxHosts bounds the number of hosts in the table; 0 means unbounded
+	maxHosts int
+	// maxAnswers bounds the address records per answer; 0 means unbounded
+	maxAnswers int
+	// stableAnswers answers with all addresses, sorted, for clients such as
+	// Envoy strict DNS clusters that treat any change as an endpoint change
+	stableAnswers bool
+	// portLabels answers _<port>.<host> with the endpoints listening on port
+	portLabels bool
+	// tenantLabel is the endpoint label matched against the tenant tag of
+	// queries carrying the tenantOption EDNS0 option
+	tenantLabel  string
+	tenantOption uint16
+	// serialOption is the EDNS0 local option code of the table serial a
+	// client last saw; 0 disables it
+	serialOption uint16
+	// namespaceOption is the EDNS0 local option code of the namespace of
+	// the querying workload, whose hosts are limited to those exported to
+	// it; 0 disables it
+	namespaceOption uint16
+	// emptyInternal is the answer for in-mesh hosts without endpoints
+	emptyInternal emptyMode
+	// chaos delays and fails queries for testing, if enabled
+	chaos *chaos
+	// dedupEndpoints drops repeated addresses from the answers of a host
+	dedupEndpoints bool
+	// versionName is answered with the build version and table serial
+	versionName string
+	// malformedNames controls query names with a URL scheme or port
+	malformedNames malformedMode
+	// emptyRcode is the rcode of queries without a question
+	emptyRcode int
+	// classRcode is the rcode of queries for classes other than IN
+	classRcode int
+	// versionBind and hostnameBind answer the CHAOS TXT queries of the same
+	// name, if set
+	versionBind  string
+	hostnameBind string
+	// allowTransfer answers AXFR and IXFR with the whole zone
+	allowTransfer bool
+	// paddingBlock is the block size responses are padded to; 0 disables
+	// padding
+	paddingBlock int
+	// compressAbove is the uncompressed size above which responses are
+	// compressed; 0 disables compression
+	compressAbove int
+	// redactNames hides query names in logs and metric labels
+	redactNames bool
+	// firstQuestion answers the first question of queries with several
+	// instead of rejecting them
+	firstQuestion bool
+	// rejectTruncated answers queries with the TC bit set with FORMERR
+	// instead of ignoring the bit
+	rejectTruncated bool
+	// answers and negatives cache the positive and negative address
+	// answers of the current table, if enabled; replaced with empty caches
+	// whenever the table changes
+	answers           *answerCache
+	negatives         *answerCache
+	answerCacheSize   int
+	negativeCacheSize int
+	negativeCacheTTL  time.Duration
+	// bothFamilies keeps an address of each family in capped answers
+	bothFamilies bool
+	// maxCNAMEChain bounds the CNAMEs followed while chasing
+	maxCNAMEChain int
+	// nonTerminals holds the empty non-terminals of the table, answered with
+	// NODATA, if enabled
+	nonTerminals      map[string]bool
+	emptyNonTerminals bool
+	// stats counts the answered queries per host, if enabled
+	stats *queryStats
+	// static holds records served regardless of the service entries
+	static staticRecords
+	// tablePrecedence answers from the table rather than the static records
+	// if both have records of a name and type
+	tablePrecedence bool
+	// synced reports whether the config store has listed the service entries
+	synced func() bool
+	// readyAt is the time, in Unix nanoseconds, the server becomes ready, or
+	// 0 before the service entries are synced; accessed atomically
+	readyAt int64
+	// warmup is how long after the first synced read the server stays unready
+	warmup time.Duration
+	// serveBeforeSync answers queries from the partial or fallback table
+	// before the first synced read instead of failing them
+	serveBeforeSync bool
+	// fallback is set while a table loaded from disk is served because the
+	// service entries have not been synced yet
+	fallback bool
+	// imported is set once a table is loaded through /admin/import; it is
+	// kept until the sync, served with serveBeforeSync. Accessed atomically.
+	imported int32
+	// importClosed is set, under mapMutex, once the service entries are
+	// synced, after which imports are refused
+	importClosed bool
+
+	subMutex    sync.Mutex
+	subscribers []*subscriber
+}
+
+// drainAnnotation marks a ServiceEntry whose endpoints are being removed.
+// Its hosts are served with --drain-ttl so that clients re-resolve soon, and
+// without records once the entry has no endpoints left.
+const drainAnnotation = "coredns.istio.io/draining"
+
+// decommissionAnnotation marks a ServiceEntry whose hosts are retired for
+// good. They answer NXDOMAIN with a negative TTL of --decommission-ttl,
+// also during --removal-grace after the entry is deleted.
+const decommissionAnnotation = "coredns.istio.io/decommissioned"
+
+// dnsEntry holds the records served for one host.
+type dnsEntry struct {
+	vips []net.IP
+	// cname is the canonical name the host is an alias of, if any
+	cname string
+	// chase adds the address records of cname to answers
+	chase bool
+	// ttl overrides defaultTTL if set
+	ttl uint32
+	// hasSubsets is set if <subset>.<host> names are served for the host
+	hasSubsets bool
+	// subsetOf is the host of a <subset>.<host> entry
+	subsetOf string
+	// changedAt is when the records of the host last changed, if they did
+	// since the server started
+	changedAt time.Time
+	// maxAnswers overrides --max-answers if set
+	maxAnswers int
+	// stable answers with all addresses, sorted, like --stable-answers
+	stable bool
+	// ordered answers the addresses in the order of the service entry,
+	// for primary/secondary failover lists
+	ordered bool
+	// ports holds the endpoint addresses by the port they listen on
+	ports map[uint32][]net.IP
+	// portEntries holds the entries of the _<port>.<host> names, built
+	// from ports with the table
+	portEntries map[uint32]*dnsEntry
+	// tenants holds the endpoint addresses by the value of their
+	// --tenant-label label
+	tenants map[string][]net.IP
+	// exportTo holds the namespaces the host is exported to, sorted; nil
+	// exports it to all
+	exportTo []string
+	// decommissioned marks a retired host, answered with NXDOMAIN
+	decommissioned bool
+	// noEndpoints marks an in-mesh host kept without records because it
+	// has no endpoints
+	noEndpoints bool
+	// rotation counts the capped answers, to rotate through the addresses;
+	// accessed atomically
+	rotation uint32
+}
+
+// equal reports whether two entries serve the same records.
+func (e *dnsEntry) equal(o *dnsEntry) bool {
+	return e.cname == o.cname && e.chase == o.chase && e.ttl == o.ttl &&
+		e.hasSubsets == o.hasSubsets && e.noEndpoints == o.noEndpoints &&
+		e.decommissioned == o.decommissioned && e.maxAnswers == o.maxAnswers && e.stable == o.stable && e.ordered == o.ordered && sameIPs(e.vips, o.vips) &&
+		samePorts(e.ports, o.ports) && sameTenants(e.tenants, o.tenants) && sameNamespaces(e.exportTo, o.exportTo)
+}
+
+// baseTTL returns the TTL of the entry's records before jitter.
+func (e *dnsEntry) baseTTL() uint32 {
+	if e.ttl > 0 {
+		return e.ttl
+	}
+	return defaultTTL
+}
+
+func (e *dnsEntry) String() string {
+	if e.cname != "" {
+		return "CNAME " + e.cname
+	}
+	return fmt.Sprintf("%v", e.vips)
 }
 
 func main() {
@@ -33,44 +280,396 @@ func main() {
 	kubeconfig := flag.String("kubeconfig", "", "path to kube config")
 	kubecontext := flag.String("context", "", "kube context to use")
 	vip := flag.String("default-address", "", "default value for A record, iff ServiceEntry has no Addresses")
+	maxStreams := flag.Uint("max-concurrent-streams", 0, "maximum number of concurrent gRPC streams per connection, 0 for the gRPC default")
+	maxRecvMsgSize := flag.Int("max-recv-msg-size", 0, "maximum size in bytes of a gRPC message the server accepts, 0 for the gRPC default")
+	maxSendMsgSize := flag.Int("max-send-msg-size", 0, "maximum size in bytes of a gRPC message the server sends, 0 for the gRPC default")
+	ttlJitter := flag.Int("ttl-jitter", 0, "percentage (0-100) by which answer TTLs are randomly spread around the default TTL")
+	typeTTLs := flag.String("qtype-ttl", "", "comma separated TYPE=seconds TTLs of answers by record type (e.g. A=300,PTR=60), replacing the default TTL of that type")
+	changeTTL := flag.Uint("change-ttl", 0, "TTL in seconds of hosts whose records changed within --change-window, so that clients pick up the change sooner; disabled if 0")
+	changeWindow := flag.Duration("change-window", time.Minute, "how long after a change of its records a host is served with --change-ttl")
+	minTTL := flag.Uint("min-ttl", 0, "lowest TTL in seconds of positive answers; lower TTLs are raised to it")
+	services := flag.Bool("kube-services", false, "also serve ClusterIP and ExternalName Kubernetes Services as <name>.<namespace>.svc.cluster.local")
+	servicesNamespace := flag.String("kube-services-namespace", "", "namespace to read Kubernetes Services from, all namespaces if empty")
+	removalGrace := flag.Duration("removal-grace", 0, "how long a host keeps resolving after it disappears from the service entries")
+	dnsCNAME := flag.String("dns-cname", "off", "answer DNS resolution ServiceEntries with a single host name endpoint and no addresses with a CNAME to it: off, cname, or chase to also resolve the CNAME target")
+	qtypes := flag.String("qtypes", "", "comma separated query types to answer (e.g. A,PTR), others get NODATA; all implemented types if empty")
+	reserved := flag.String("reserved-names", "", "comma separated names, with their subdomains, never answered from the service entries, each optionally followed by =nxdomain (the default) or =refused, e.g. kubernetes.default.svc.cluster.local,metadata.google.internal=refused")
+	specialNames := flag.Bool("special-names", false, "answer localhost names with the loopback addresses and .invalid and .test names with NXDOMAIN (RFC 6761) without consulting the service entries")
+	drainTTL := flag.Uint("drain-ttl", 5, "TTL in seconds of hosts whose ServiceEntry is annotated "+drainAnnotation)
+	decommissionTTL := flag.Uint("decommission-ttl", 3600, "negative TTL in seconds of the NXDOMAIN answers for hosts whose ServiceEntry is annotated "+decommissionAnnotation)
+	subsets := flag.Bool("subsets", false, "serve <subset>.<host> with the endpoints of the host's ServiceEntry matching the DestinationRule subset")
+	wildcardAddress := flag.String("wildcard-address", "", "value for A records of wildcard hosts, iff ServiceEntry has no Addresses and --default-address is not set; such wildcards have no records if empty")
+	rfc6724 := flag.Bool("rfc6724-order", false, "order answered addresses for the gRPC client address per RFC 6724 destination address selection")
+	familyOrder := flag.String("family-order", "none", "arrangement of IPv4 and IPv6 addresses in answers with both, applied after the other ordering: none, ipv4 or ipv6 to list that family first, or interleave")
+	allow := flag.String("allow", "", "comma separated CIDRs of clients allowed to query, all clients if empty")
+	deny := flag.String("deny", "", "comma separated CIDRs of clients refused, takes precedence over --allow")
+	sidecar := flag.String("sidecar", "", "namespace/name of a Sidecar whose egress hosts limit the hosts served")
+	nameserver := flag.String("nameserver", "", "nameserver name used in the SOA and NS records of exported zones, ns.<zone> if empty")
+	zones := flag.String("zones", "", "comma separated zones the service entry hosts are served as, whose apex owns the SOA of negative answers; a name outside them is taken to be in the zone of its last two labels")
+	nameserverAddress := flag.String("nameserver-address", "", "comma separated addresses of this server, answered for A and AAAA queries of the --nameserver name")
+	adminAddress := flag.String("admin-address", "", "address of the admin HTTP server serving /healthz, disabled if empty")
+	maxHosts := flag.Int("max-hosts", 0, "maximum number of hosts in the DNS table, evicting MESH_EXTERNAL before MESH_INTERNAL and older before newer service entries; unbounded if 0")
+	maxAnswers := flag.Int("max-answers", 0, "maximum number of address records per answer, rotating through the addresses of larger hosts; unbounded if 0")
+	fallbackTable := flag.String("fallback-table", "", "path to a DNS table, in the format of /debug/table, served until the service entries are synced")
+	emptyInternal := flag.String("empty-internal", "nxdomain", "answer for MESH_INTERNAL hosts without addresses and endpoints: nxdomain, nodata, or servfail, so that clients keep retrying while the service scales up")
+	stableAnswers := flag.Bool("stable-answers", false, "answer with all addresses of a host in sorted order, without --max-answers or --rfc6724-order, as Envoy strict DNS clusters expect")
+	tenantLabel := flag.String("tenant-label", "", "endpoint label whose value queries can select endpoints by with a tenant tag in an EDNS0 local option; disabled if empty")
+	serialOption := flag.Uint("serial-option", 0, "EDNS0 local option code in which clients send the table serial they last saw, answered without records if the table has not changed since; disabled if 0")
+	tenantOption := flag.Uint("tenant-option", defaultTenantOption, "EDNS0 local option code carrying the tenant tag of --tenant-label")
+	namespaceOption := flag.Uint("namespace-option", 0, "EDNS0 local option code in which clients send the namespace of the querying workload, answered only for the hosts of service entries exported to it; disabled if 0")
+	portLabels := flag.Bool("port-labels", false, "answer A and AAAA queries for _<port>.<host> with the addresses of the host's endpoints listening on port")
+	staticFile := flag.String("static-records", "", "path to a zone file of records served regardless of the service entries")
+	tablePrecedence := flag.Bool("static-records-override", false, "let records from the service entries take precedence over --static-records of the same name and type")
+	dedupEndpoints := flag.Bool("dedup-endpoints", true, "answer an address listed more than once for a host, or for a port of a host, only once")
+	versionName := flag.String("version-name", "", "name whose TXT query is answered with the build version and the table serial, e.g. version.istio-coredns; disabled if empty")
+	compressAbove := flag.Int("compress-above", 0, "compress responses whose uncompressed size exceeds this many bytes; responses are never compressed if 0")
+	malformedNames := flag.String("malformed-names", "off", "handling of query names with a URL scheme or port, such as http://foo.com or foo.com:8080: off to look them up as they are, strip to answer for the name without them, or formerr")
+	emptyQuestion := flag.String("empty-question", "formerr", "answer to queries without a question: formerr or refused")
+	unsupportedClass := flag.String("unsupported-class", "notimp", "answer to queries for classes other than IN: notimp or refused")
+	versionBind := flag.String("version-bind", "", "answer to CH TXT version.bind queries; not answered if empty")
+	hostnameBind := flag.String("hostname-bind", "", "answer to CH TXT hostname.bind queries; not answered if empty")
+	allowTransfer := flag.Bool("allow-transfer", false, "answer AXFR and IXFR requests of clients passing --allow and --deny with the whole zone in one message; refused if false")
+	paddingBlock := flag.Int("padding-block", 0, "pad responses to EDNS queries to a multiple of this many bytes (RFC 7830, 468 is recommended by RFC 8467); no padding if 0")
+	redactNames := flag.Bool("redact-names", false, "replace query names in logs and metric labels with a token derived from a hash of the name")
+	bothFamilies := flag.Bool("keep-both-families", false, "make ANY answers capped by --max-answers include an IPv4 and an IPv6 address if the host has both")
+	maxCNAMEChain := flag.Int("max-cname-chain", defaultMaxCNAMEChain, "longest chain of CNAMEs answered while chasing, counting the CNAME of the queried name; longer chains and loops are answered with SERVFAIL")
+	emptyNonTerminals := flag.Bool("empty-non-terminals", true, "answer names without records of their own but with names below them that have some with NODATA instead of NXDOMAIN (RFC 8020)")
+	answerCacheSize := flag.Int("answer-cache-size", 0, "cache up to this many assembled address answers until their TTL expires or the table changes; no cache if 0")
+	negativeCacheSize := flag.Int("negative-cache-size", 0, "cache up to this many negative address answers, for hosts or address families the table does not have, until --negative-cache-ttl passes or the table changes; no cache if 0")
+	negativeCacheTTL := flag.Duration("negative-cache-ttl", 5*time.Second, "how long --negative-cache-size caches negative answers")
+	truncatedQuery := flag.String("truncated-query", "ignore", "answer to queries with the TC bit set: ignore to answer them as if it were clear, or formerr")
+	multiQuestion := flag.String("multi-question", "formerr", "answer to queries with more than one question: formerr, or first to answer the first question only")
+	queryStatsEnabled := flag.Bool("query-stats", false, "count answered queries per host and query type, shown on /debug/queries on the admin server")
+	queryStatsReset := flag.Duration("query-stats-reset", 0, "interval at which the per-host query counts are reset, never if 0")
+	queryStatsMetricHosts := flag.Int("query-stats-metric-hosts", 0, "number of hosts whose query counts are also exported as metrics, the rest are counted as \"other\"")
+	enableReflection := flag.Bool("grpc-reflection", false, "enable gRPC server reflection, e.g. for grpcurl")
+	enableChaos := flag.Bool("chaos", false, "allow delaying and failing queries through /admin/chaos on the admin server, for resilience testing only")
+	enablePprof := flag.Bool("pprof", false, "serve profiles under /debug/pprof/ on the admin server")
+	enableImport := flag.Bool("import", false, "accept a table exported by another instance through /admin/import on the admin server until the service entries are synced")
+	serveBeforeSync := flag.Bool("serve-before-sync", false, "answer queries from the partially read service entries before they are synced, instead of with SERVFAIL")
+	warmup := flag.Duration("warmup", 0, "how long after the service entries are first synced /readyz keeps failing and queries are answered with SERVFAIL, unless served before the sync")
+	sourceLossCutoff := flag.Duration("source-loss-cutoff", 0, "answer every query with SERVFAIL once the Kubernetes API server has been unreachable for this long, rather than serve stale data; disabled if 0")
+	watchdogTimeout := flag.Duration("watchdog-timeout", 30*time.Second, "how long the service entry watcher may go without a read before /healthz fails")
 
 	flag.Parse()
 
-	h, err := NewIstioHandle(*kubeconfig, *kubecontext)
+	h, err := NewIstioHandle(*kubeconfig, *kubecontext, *subsets)
 	if err != nil {
 		log.Fatalf("Failed to initialize Istio CRD watcher: %v", err)
 	}
+	if *ttlJitter < 0 || *ttlJitter > 100 {
+		log.Fatalf("Invalid --ttl-jitter %d, must be between 0 and 100", *ttlJitter)
+	}
+	h.ttlJitter = *ttlJitter
+	h.minTTL = uint32(*minTTL)
+	h.changeTTL = uint32(*changeTTL)
+	h.changeWindow = *changeWindow
+	h.removalGrace = *removalGrace
+	h.specialNames = *specialNames
+	h.nameserver = *nameserver
+	h.zones = parseZones(*zones)
+	h.rfc6724 = *rfc6724
+	h.portLabels = *portLabels
+	h.tenantLabel = *tenantLabel
+	if *serialOption != 0 && (*serialOption < dns.EDNS0LOCALSTART || *serialOption > dns.EDNS0LOCALEND || *serialOption == *tenantOption) {
+		log.Fatalf("Invalid --serial-option %d, must be between %d and %d and differ from --tenant-option", *serialOption, dns.EDNS0LOCALSTART, dns.EDNS0LOCALEND)
+	}
+	h.serialOption = uint16(*serialOption)
+	if *tenantOption < dns.EDNS0LOCALSTART || *tenantOption > dns.EDNS0LOCALEND {
+		log.Fatalf("Invalid --tenant-option %d, must be between %d and %d", *tenantOption, dns.EDNS0LOCALSTART, dns.EDNS0LOCALEND)
+	}
+	h.tenantOption = uint16(*tenantOption)
+	if *namespaceOption != 0 && (*namespaceOption < dns.EDNS0LOCALSTART || *namespaceOption > dns.EDNS0LOCALEND || *namespaceOption == *tenantOption || *namespaceOption == *serialOption) {
+		log.Fatalf("Invalid --namespace-option %d, must be between %d and %d and differ from --tenant-option and --serial-option", *namespaceOption, dns.EDNS0LOCALSTART, dns.EDNS0LOCALEND)
+	}
+	h.namespaceOption = uint16(*namespaceOption)
+	h.stableAnswers = *stableAnswers
+	if *staticFile != "" {
+		if h.static, err = loadStaticRecords(*staticFile); err != nil {
+			log.Fatalf("Failed to load static records: %v", err)
+		}
+	}
+	h.tablePrecedence = *tablePrecedence
+	h.allowTransfer = *allowTransfer
+	switch *emptyQuestion {
+	case "formerr":
+		h.emptyRcode = dns.RcodeFormatError
+	case "refused":
+		h.emptyRcode = dns.RcodeRefused
+	default:
+		log.Fatalf("Invalid --empty-question %q, expected formerr or refused", *emptyQuestion)
+	}
+	switch *unsupportedClass {
+	case "notimp":
+		h.classRcode = dns.RcodeNotImplemented
+	case "refused":
+		h.classRcode = dns.RcodeRefused
+	default:
+		log.Fatalf("Invalid --unsupported-class %q, expected notimp or refused", *unsupportedClass)
+	}
+	h.versionBind = *versionBind
+	h.dedupEndpoints = *dedupEndpoints
+	if *versionName != "" {
+		h.versionName = strings.ToLower(dns.Fqdn(*versionName))
+	}
+	h.hostnameBind = *hostnameBind
+	h.redactNames = *redactNames
+	if *paddingBlock < 0 || *paddingBlock > dns.MaxMsgSize {
+		log.Fatalf("Invalid --padding-block %d", *paddingBlock)
+	}
+	h.paddingBlock = *paddingBlock
+	if *compressAbove < 0 {
+		log.Fatalf("Invalid --compress-above %d", *compressAbove)
+	}
+	h.compressAbove = *compressAbove
+	switch *multiQuestion {
+	case "formerr":
+	case "first":
+		h.firstQuestion = true
+	default:
+		log.Fatalf("Invalid --multi-question %q, expected formerr or first", *multiQuestion)
+	}
+	switch *truncatedQuery {
+	case "ignore":
+	case "formerr":
+		h.rejectTruncated = true
+	default:
+		log.Fatalf("Invalid --truncated-query %q, expected ignore or formerr", *truncatedQuery)
+	}
+	if *answerCacheSize < 0 {
+		log.Fatalf("Invalid --answer-cache-size %d", *answerCacheSize)
+	}
+	h.answerCacheSize = *answerCacheSize
+	if *negativeCacheSize < 0 || *negativeCacheTTL <= 0 {
+		log.Fatalf("Invalid --negative-cache-size %d or --negative-cache-ttl %v", *negativeCacheSize, *negativeCacheTTL)
+	}
+	h.negativeCacheSize = *negativeCacheSize
+	h.negativeCacheTTL = *negativeCacheTTL
+	h.emptyNonTerminals = *emptyNonTerminals
+	if *maxCNAMEChain < 1 {
+		log.Fatalf("Invalid --max-cname-chain %d", *maxCNAMEChain)
+	}
+	h.maxCNAMEChain = *maxCNAMEChain
+	h.bothFamilies = *bothFamilies
+	h.resetCaches()
+	if *queryStatsEnabled {
+		h.stats = newQueryStats(*queryStatsMetricHosts)
+		if *queryStatsReset > 0 {
+			go h.stats.resetEvery(*queryStatsReset, h.stop)
+		}
+	}
+	if *enableChaos {
+		log.Printf("Chaos injection enabled, do not use in production\n")
+		h.chaos = newChaos(rand.NewSource(time.Now().UnixNano()))
+	}
+	if *wildcardAddress != "" {
+		if h.wildcardAddress = net.ParseIP(*wildcardAddress); h.wildcardAddress == nil {
+			log.Fatalf("Invalid --wildcard-address %q", *wildcardAddress)
+		}
+	}
+	if *nameserverAddress != "" {
+		if *nameserver == "" {
+			log.Fatalf("--nameserver-address requires --nameserver")
+		}
+		for _, address := range strings.Split(*nameserverAddress, ",") {
+			ip := net.ParseIP(strings.TrimSpace(address))
+			if ip == nil {
+				log.Fatalf("Invalid --nameserver-address %q", address)
+			}
+			h.nameserverIPs = append(h.nameserverIPs, ip)
+		}
+	}
+	if *drainTTL == 0 {
+		log.Fatalf("Invalid --drain-ttl 0, must be at least 1")
+	}
+	h.drainTTL = uint32(*drainTTL)
+	h.decommissionTTL = uint32(*decommissionTTL)
+	if *maxHosts < 0 {
+		log.Fatalf("Invalid --max-hosts %d, must not be negative", *maxHosts)
+	}
+	h.maxHosts = *maxHosts
+	if *maxAnswers < 0 {
+		log.Fatalf("Invalid --max-answers %d, must not be negative", *maxAnswers)
+	}
+	h.maxAnswers = *maxAnswers
+	if *maxStreams > math.MaxUint32 {
+		log.Fatalf("Invalid --max-concurrent-streams %d, must be at most %d", *maxStreams, uint32(math.MaxUint32))
+	}
+	if *maxRecvMsgSize < 0 || *maxSendMsgSize < 0 {
+		log.Fatalf("Invalid --max-recv-msg-size %d or --max-send-msg-size %d, must not be negative", *maxRecvMsgSize, *maxSendMsgSize)
+	}
+	h.watchdogTimeout = *watchdogTimeout
+	if *sourceLossCutoff > 0 {
+		if h.sourceProbe, err = newSourceProbe(*kubeconfig, *kubecontext); err != nil {
+			log.Fatalf("Failed to initialize Kubernetes API server probe: %v", err)
+		}
+		h.sourceLossCutoff = *sourceLossCutoff
+		atomic.StoreInt64(&h.lastContact, time.Now().UnixNano())
+	}
+	h.warmup = *warmup
+	h.serveBeforeSync = *serveBeforeSync
+	if h.cnameMode, err = parseCNAMEMode(*dnsCNAME); err != nil {
+		log.Fatalf("Invalid --dns-cname: %v", err)
+	}
+	if h.familyOrder, err = parseFamilyOrder(*familyOrder); err != nil {
+		log.Fatalf("Invalid --family-order: %v", err)
+	}
+	if h.emptyInternal, err = parseEmptyMode(*emptyInternal); err != nil {
+		log.Fatalf("Invalid --empty-internal: %v", err)
+	}
+	if h.malformedNames, err = parseMalformedMode(*malformedNames); err != nil {
+		log.Fatalf("Invalid --malformed-names: %v", err)
+	}
+	if h.reserved, err = parseReservedNames(*reserved); err != nil {
+		log.Fatalf("Invalid --reserved-names: %v", err)
+	}
+	if h.typeTTLs, err = parseTypeTTLs(*typeTTLs); err != nil {
+		log.Fatalf("Invalid --qtype-ttl: %v", err)
+	}
+	if h.qtypes, err = parseQtypes(*qtypes); err != nil {
+		log.Fatalf("Invalid --qtypes: %v", err)
+	}
+	if h.acl, err = newACL(*allow, *deny); err != nil {
+		log.Fatalf("Invalid client ACL: %v", err)
+	}
+	if *services {
+		if h.services, err = newServiceSource(*kubeconfig, *kubecontext, *servicesNamespace, h.stop); err != nil {
+			log.Fatalf("Failed to initialize Kubernetes Service watcher: %v", err)
+		}
+	}
+	if *sidecar != "" {
+		if h.sidecar, err = newSidecarScope(*kubeconfig, *kubecontext, *sidecar); err != nil {
+			log.Fatalf("Failed to read Sidecar %s: %v", *sidecar, err)
+		}
+	}
+
+	if *fallbackTable != "" {
+		if h.dnsEntries, h.ptrEntries, err = loadTable(*fallbackTable); err != nil {
+			log.Fatalf("Failed to load fallback table: %v", err)
+		}
+		log.Printf("Loaded %d hosts from fallback table %s\n", len(h.dnsEntries), *fallbackTable)
+		h.fallback = true
+		// the fallback table is there to be served until the sync
+		h.serveBeforeSync = true
+	}
 
 	h.readServiceEntries(*vip)
+	h.heartbeat()
 	stop := make(chan bool)
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		for {
-			select {
-			case <-stop:
-				return
-			case <-ticker.C:
-				h.readServiceEntries(*vip)
-			}
-		}
-	}()
+	go h.watch(*vip, 5*time.Second, stop)
+
+	if *adminAddress != "" {
+		go func() {
+			log.Fatalf("Admin server failed: %v", http.ListenAndServe(*adminAddress, h.adminHandler(*enablePprof, *enableImport)))
+		}()
+	}
 
 	// start server
 	listener, err := net.Listen("tcp", ":8053")
 	if err != nil {
 		log.Fatalf("Failed to start grpc server: %v", err)
 	}
-	grpcServer := grpc.NewServer()
+	grpcServer := newGRPCServer(h, *enableReflection, serverOptions(uint32(*maxStreams), *maxRecvMsgSize, *maxSendMsgSize)...)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Received %v, shutting down\n", sig)
+		grpcServer.GracefulStop()
+	}()
+	if err := grpcServer.Serve(listener); err != nil {
+		log.Printf("gRPC server stopped: %v\n", err)
+	}
+	// stop the watcher, the informers and the other background work
+	close(stop)
+	close(h.stop)
+}
 
+// newGRPCServer returns a gRPC server of the DNS service answering from h,
+// which also serves reflection if enableReflection is set.
+func newGRPCServer(h *IstioServiceEntries, enableReflection bool, opts ...grpc.ServerOption) *grpc.Server {
+	grpcServer := grpc.NewServer(opts...)
 	dnsapi.RegisterDnsServiceServer(grpcServer, h)
-	grpcServer.Serve(listener)
-	close(h.stop)
+	if enableReflection {
+		reflection.Register(grpcServer)
+	}
+	return grpcServer
+}
+
+// serverOptions returns the gRPC server options for the given limits. Zero
+// values leave the corresponding gRPC default in place.
+func serverOptions(maxStreams uint32, maxRecvMsgSize int, maxSendMsgSize int) []grpc.ServerOption {
+	opts := make([]grpc.ServerOption, 0)
+	if maxStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(maxStreams))
+	}
+	if maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(maxRecvMsgSize))
+	}
+	if maxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(maxSendMsgSize))
+	}
+	return opts
+}
+
+// parseQtypes parses a comma separated list of query type names. An empty
+// list yields nil, meaning all types are enabled.
+func parseQtypes(list string) (map[uint16]bool, error) {
+	var qtypes map[uint16]bool
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		qtype, found := dns.StringToType[name]
+		if !found {
+			return nil, fmt.Errorf("unknown query type %q", name)
+		}
+		if qtypes == nil {
+			qtypes = make(map[uint16]bool)
+		}
+		qtypes[qtype] = true
+	}
+	return qtypes, nil
 }
 
 func (h *IstioServiceEntries) readServiceEntries(vip string) {
+	// The source is probed also while a fallback or imported table is
+	// served, so that its loss is noticed before it syncs
+	h.probeSource()
+	synced := h.synced()
+	if synced {
+		h.mapMutex.Lock()
+		h.importClosed = true
+		h.mapMutex.Unlock()
+	} else if atomic.LoadInt32(&h.imported) != 0 {
+		log.Printf("Service entries not synced yet, serving the imported table\n")
+		return
+	}
+	if h.fallback {
+		if !synced {
+			log.Printf("Service entries not synced yet, serving the fallback table\n")
+			return
+		}
+		log.Printf("Service entries synced, replacing the fallback table\n")
+		h.fallback = false
+	}
 	log.Printf("Reading service entries at %v\n", time.Now())
-	dnsEntries := make(map[string][]net.IP)
+	if h.sidecar != nil {
+		if err := h.sidecar.refresh(); err != nil {
+			log.Printf("Using previous Sidecar egress hosts: %v\n", err)
+		}
+	}
+	dnsEntries := make(map[string]*dnsEntry)
+	if h.services != nil {
+		// Service entries take precedence over Kubernetes Services of the same name
+		h.services.addEntries(dnsEntries, h.sidecar)
+	}
+	ptrEntries := make(map[string][]string)
+	origins := make(map[string]hostOrigin)
+	duplicates := 0
 	serviceEntries := h.configStore.ServiceEntries()
+	subsets := h.readSubsets()
 	log.Printf("Have %d service entries\n", len(serviceEntries))
 	for _, e := range serviceEntries {
 		entry := e.Spec.(*networking.ServiceEntry)
@@ -87,45 +686,256 @@ func (h *IstioServiceEntries) readServiceEntries(vip string) {
 			continue
 		}
 
-		addresses := entry.Addresses
-		if len(addresses) == 0 && vip != "" {
-			// If the ServiceEntry has no Addresses, map to a user-supplied default value, if provided
-			addresses = []string{vip}
+		var ttl uint32
+		drained := false
+		if e.Annotations[drainAnnotation] == "true" {
+			ttl = h.drainTTL
+			// Once the endpoints are gone the hosts stay known, without
+			// records; hosts with addresses keep serving them
+			drained = len(entry.Addresses) == 0 && len(entry.Endpoints) == 0
 		}
 
-		vips := convertToVIPs(addresses)
-		if len(vips) == 0 {
-			continue
+		maxAnswers := entryMaxAnswers(e.Name, e.Namespace, e.Annotations)
+		stable := e.Annotations[stableAnnotation] == "true"
+		ordered := e.Annotations[orderedAnnotation] == "true"
+		ports := endpointPorts(entry)
+		tenants := endpointTenants(entry, h.tenantLabel)
+		exportTo := entryExportTo(e.Namespace, entry.ExportTo)
+		if h.dedupEndpoints {
+			duplicates += dedupIndex(ports)
+			for tenant, ips := range tenants {
+				var n int
+				tenants[tenant], n = dedupIPs(ips)
+				duplicates += n
+			}
+		}
+
+		var target string
+		mode := h.entryCNAMEMode(e.Name, e.Namespace, e.Annotations)
+		if mode != cnameOff && len(entry.Addresses) == 0 && !drained {
+			target = externalName(entry)
+		}
+
+		var vips []net.IP
+		if target == "" && !drained {
+			addresses := entry.Addresses
+			if len(addresses) == 0 && vip != "" {
+				// If the ServiceEntry has no Addresses, map to a user-supplied default value, if provided
+				addresses = []string{vip}
+			}
+
+			vips = convertToVIPs(addresses)
+			if h.dedupEndpoints {
+				var n int
+				vips, n = dedupIPs(vips)
+				duplicates += n
+			}
 		}
 
 		for _, host := range entry.Hosts {
-			key := fmt.Sprintf("%s.", host)
-			if strings.Contains(host, "*") {
-				// Validation will ensure that the host is of the form *.foo.com
-				parts := strings.SplitN(host, ".", 2)
-				// Prefix wildcards with a . so that we can distinguish these entries in the map
-				key = fmt.Sprintf(".%s.", parts[1])
+			if h.sidecar != nil && !h.sidecar.allows(e.Namespace, host) {
+				// not reachable from the workloads selected by the Sidecar
+				continue
+			}
+			if e.Annotations[decommissionAnnotation] == "true" {
+				dnsEntries[tableKey(host)] = &dnsEntry{decommissioned: true}
+				continue
+			}
+			hostVIPs := vips
+			noEndpoints := false
+			if len(vips) == 0 && target == "" && !drained {
+				if !strings.Contains(host, "*") {
+					if !h.keepWithoutEndpoints(entry) {
+						continue
+					}
+					// Known in-mesh host that may get endpoints soon
+					noEndpoints = true
+				} else if h.wildcardAddress != nil {
+					// A wildcard without addresses answers with the wildcard
+					// address if configured, and without records otherwise
+					hostVIPs = []net.IP{h.wildcardAddress}
+				}
+			}
+			key := tableKey(host)
+			origin := hostOrigin{location: entry.Location, created: e.CreationTimestamp}
+			dnsEntries[key] = &dnsEntry{vips: hostVIPs, cname: target, chase: target != "" && mode == cnameChase, ttl: ttl, maxAnswers: maxAnswers, stable: stable, ordered: ordered, ports: ports, tenants: tenants, exportTo: exportTo, noEndpoints: noEndpoints}
+			dnsEntries[key].portEntries = portEntries(dnsEntries[key])
+			origins[key] = origin
+			if hostSubsets := subsets[canonicalHost(host)]; len(hostSubsets) > 0 && !strings.Contains(host, "*") {
+				dnsEntries[key].hasSubsets = true
+				addSubsetEntries(dnsEntries, key, entry, hostSubsets, ttl)
+				for _, subset := range hostSubsets {
+					dnsEntries[subset.Name+"."+key].exportTo = exportTo
+					origins[subset.Name+"."+key] = origin
+				}
+			}
+			if len(entry.Addresses) > 0 && !strings.Contains(host, "*") {
+				addReverseEntries(ptrEntries, key, vips)
 			}
-			dnsEntries[key] = vips
 		}
 	}
-	h.mapMutex.Lock()
-	h.dnsEntries = make(map[string][]net.IP)
+	// Removed hosts are retained before eviction, which drops them first,
+	// and keep their reverse entries
+	previous := h.view()
+	for _, k := range h.retainRemoved(dnsEntries, time.Now()) {
+		origins[k] = hostOrigin{removed: true}
+		retainReverseEntries(ptrEntries, previous.ptrEntries, k)
+	}
+	evicted := 0
+	if h.maxHosts > 0 && len(dnsEntries) > h.maxHosts {
+		evicted = evictHosts(dnsEntries, origins, h.maxHosts)
+		evicted += dropOrphanSubsets(dnsEntries)
+		prunePTREntries(ptrEntries, dnsEntries)
+	}
+	for _, names := range ptrEntries {
+		sort.Strings(names)
+	}
 	for k, v := range dnsEntries {
 		log.Printf("adding DNS mapping: %s->%v\n", k, v)
-		h.dnsEntries[k] = v
+	}
+	var nonTerminals map[string]bool
+	if h.emptyNonTerminals {
+		nonTerminals = emptyNonTerminals(dnsEntries, ptrEntries, h.static, h.zones)
+	}
+	// The tables are replaced, never modified, so the old one can be read
+	// without holding the lock while the diff is computed.
+	diff := diffEntries(previous.dnsEntries, dnsEntries)
+	markChanges(previous.dnsEntries, dnsEntries, diff, time.Now())
+	h.mapMutex.Lock()
+	if !synced && atomic.LoadInt32(&h.imported) != 0 {
+		// a table was imported during the read, keep serving it
+		h.mapMutex.Unlock()
+		return
+	}
+	h.dnsEntries = dnsEntries
+	h.ptrEntries = ptrEntries
+	h.nonTerminals = nonTerminals
+	if !diff.empty() {
+		h.serial++
+		h.resetCaches()
 	}
 	h.mapMutex.Unlock()
+	// Gauges, as the same hosts and addresses are dropped again on every read
+	evictedHosts.Set(float64(evicted))
+	duplicateEndpoints.Set(float64(duplicates))
+	h.publish(diff)
+	if synced {
+		h.markSynced()
+	}
 	//log.Printf("Found %d service entries and have %v\n", len(serviceEntries), h.dnsEntries)
 }
 
+// tableKey returns the key of host in the DNS table.
+func tableKey(host string) string {
+	host = canonicalHost(host)
+	key := fmt.Sprintf("%s.", host)
+	if strings.Contains(host, "*") {
+		// Validation will ensure that the host is of the form *.foo.com
+		parts := strings.SplitN(host, ".", 2)
+		// Prefix wildcards with a . so that we can distinguish these entries in the map
+		key = fmt.Sprintf(".%s.", parts[1])
+	}
+	return key
+}
+
+// canonicalHost returns host in lower case without a trailing dot, the form
+// hosts are compared in; table keys and query names are in FQDN form instead.
+func canonicalHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// snapshot returns the current DNS table. The table is replaced, never
+// modified, on reads, so it may be used without holding the lock.
+func (h *IstioServiceEntries) snapshot() map[string]*dnsEntry {
+	h.mapMutex.RLock()
+	defer h.mapMutex.RUnlock()
+	return h.dnsEntries
+}
+
+// tableView is a consistent view of the forward and reverse DNS tables.
+type tableView struct {
+	dnsEntries map[string]*dnsEntry
+	ptrEntries map[string][]string
+	// answers caches answers built from these tables
+	answers      *answerCache
+	negatives    *answerCache
+	nonTerminals map[string]bool
+	serial       uint32
+}
+
+// view returns the current DNS tables; like snapshot, it may be used
+// without holding the lock.
+func (h *IstioServiceEntries) view() tableView {
+	h.mapMutex.RLock()
+	defer h.mapMutex.RUnlock()
+	return tableView{dnsEntries: h.dnsEntries, ptrEntries: h.ptrEntries, answers: h.answers, negatives: h.negatives, nonTerminals: h.nonTerminals, serial: h.serial}
+}
+
+// retainRemoved copies hosts that are in the current table but missing from
+// dnsEntries into dnsEntries, until they have been missing for longer than the
+// removal grace period. Hosts that reappear are forgotten as removed. It
+// returns the retained hosts.
+func (h *IstioServiceEntries) retainRemoved(dnsEntries map[string]*dnsEntry, now time.Time) []string {
+	if h.removalGrace <= 0 {
+		return nil
+	}
+	if h.removedAt == nil {
+		h.removedAt = make(map[string]time.Time)
+	}
+	var retained []string
+	for k := range h.removedAt {
+		if _, found := dnsEntries[k]; found {
+			log.Printf("DNS mapping %s is back\n", k)
+			delete(h.removedAt, k)
+		}
+	}
+	for k, v := range h.snapshot() {
+		if _, found := dnsEntries[k]; found {
+			continue
+		}
+		if parent, found := dnsEntries[v.subsetOf]; found && !parent.hasSubsets {
+			// the host is still served, without subsets: decommissioned,
+			// or its DestinationRule is gone
+			log.Printf("removing DNS mapping: %s->%v\n", k, v)
+			delete(h.removedAt, k)
+			continue
+		}
+		removed, found := h.removedAt[k]
+		if !found {
+			removed = now
+			h.removedAt[k] = now
+		}
+		if now.Sub(removed) > h.removalGrace {
+			log.Printf("removing DNS mapping: %s->%v\n", k, v)
+			delete(h.removedAt, k)
+			continue
+		}
+		log.Printf("keeping removed DNS mapping until %v: %s->%v\n", removed.Add(h.removalGrace), k, v)
+		dnsEntries[k] = v
+		retained = append(retained, k)
+	}
+	return retained
+}
+
+// retainReverseEntries copies the reverse entries naming host from the
+// previous reverse table.
+func retainReverseEntries(ptrEntries map[string][]string, previous map[string][]string, host string) {
+	for name, hosts := range previous {
+		for _, h := range hosts {
+			if h == host {
+				ptrEntries[name] = append(ptrEntries[name], host)
+			}
+		}
+	}
+}
+
 func convertToVIPs(addresses []string) []net.IP {
 	vips := make([]net.IP, 0)
 
 	for _, address := range addresses {
 		// check if its CIDR.  If so, reject the address unless its /32 CIDR
 		if strings.Contains(address, "/") {
-			if ip, network, err := net.ParseCIDR(address); err != nil {
+			if ip, network, err := net.ParseCIDR(address); err == nil {
 				ones, bits := network.Mask.Size()
 				if ones == bits {
 					// its a full mask (e.g., /32). Effectively an IP
@@ -142,10 +952,54 @@ func convertToVIPs(addresses []string) []net.IP {
 	return vips
 }
 
+// addReverseEntries records host as a name for each of the given addresses.
+func addReverseEntries(ptrEntries map[string][]string, host string, vips []net.IP) {
+	for _, ip := range vips {
+		name, err := dns.ReverseAddr(ip.String())
+		if err != nil {
+			continue
+		}
+		found := false
+		for _, existing := range ptrEntries[name] {
+			if existing == host {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ptrEntries[name] = append(ptrEntries[name], host)
+		}
+	}
+}
+
 // code based on https://github.com/ahmetb/coredns-grpc-backend-sample
 func (h *IstioServiceEntries) Query(ctx context.Context, in *dnsapi.DnsPacket) (*dnsapi.DnsPacket, error) {
+	return h.query(ctx, in, h.view())
+}
+
+// BatchQuery answers every packet of the batch, in order, from the same view
+// of the DNS table.
+func (h *IstioServiceEntries) BatchQuery(ctx context.Context, in *dnsapi.DnsBatch) (*dnsapi.DnsBatch, error) {
+	v := h.view()
+	out := &dnsapi.DnsBatch{Packets: make([]*dnsapi.DnsPacket, 0, len(in.Packets))}
+	for i, packet := range in.Packets {
+		if err := abandoned(ctx); err != nil {
+			return nil, err
+		}
+		reply, err := h.query(ctx, packet, v)
+		if err != nil {
+			return nil, fmt.Errorf("packet %d: %v", i, err)
+		}
+		out.Packets = append(out.Packets, reply)
+	}
+	return out, nil
+}
+
+// query answers the DNS query in the packet from the given view.
+func (h *IstioServiceEntries) query(ctx context.Context, in *dnsapi.DnsPacket, v tableView) (*dnsapi.DnsPacket, error) {
 	request := new(dns.Msg)
-	if err := request.Unpack(in.Msg); err != nil {
+	// ErrTruncated only reports the TC bit of a fully unpacked message
+	if err := request.Unpack(in.Msg); err != nil && err != dns.ErrTruncated {
 		return nil, fmt.Errorf("failed to unmarshall dns query: %v", err)
 	}
 
@@ -153,43 +1007,277 @@ func (h *IstioServiceEntries) Query(ctx context.Context, in *dnsapi.DnsPacket) (
 	response.SetReply(request)
 	response.Authoritative = true
 
-	log.Println("DNS query ", request)
+	h.logQuery(request)
+	client := requestClient(ctx, request)
+	if !h.acl.permits(client) {
+		log.Printf("Refusing query from %v\n", client)
+		response.Rcode = dns.RcodeRefused
+		setExtendedError(request, response, failureProhibited)
+		return h.reply(request, response)
+	}
+	if h.sourceLost() {
+		log.Printf("Service entry source lost, failing query\n")
+		response.Rcode = dns.RcodeServerFailure
+		setExtendedError(request, response, failureSourceLost)
+		return h.reply(request, response)
+	}
+	if h.gated() {
+		log.Printf("Not ready, failing query\n")
+		response.Rcode = dns.RcodeServerFailure
+		setExtendedError(request, response, failureNotReady)
+		return h.reply(request, response)
+	}
+	rcode, fail := h.chaos.inject(ctx)
+	if err := abandoned(ctx); err != nil {
+		return nil, err
+	}
+	if fail {
+		log.Printf("Chaos: answering %s\n", dns.RcodeToString[rcode])
+		response.Rcode = rcode
+		return h.reply(request, response)
+	}
+	if request.Truncated && h.rejectTruncated {
+		// TC has no meaning in a query; it is set by broken or replayed
+		// clients. The reply never carries it over.
+		log.Printf("Rejecting query with the TC bit set\n")
+		response.Rcode = dns.RcodeFormatError
+		return h.reply(request, response)
+	}
+	if len(request.Question) == 0 {
+		log.Printf("Answering query without a question with %s\n", dns.RcodeToString[h.emptyRcode])
+		response.Rcode = h.emptyRcode
+		return h.reply(request, response)
+	}
+	if len(request.Question) > 1 {
+		// RFC 9619: a query carries at most one question, and the
+		// semantics of several were never defined
+		if !h.firstQuestion {
+			log.Printf("Rejecting query with %d questions\n", len(request.Question))
+			response.Rcode = dns.RcodeFormatError
+			return h.reply(request, response)
+		}
+		log.Printf("Answering the first of %d questions\n", len(request.Question))
+		request.Question = request.Question[:1]
+	}
+	if h.serialOption != 0 {
+		if seen, found := requestSerial(request, h.serialOption); found {
+			setSerial(request, response, h.serialOption, v.serial)
+			if seen == v.serial {
+				log.Printf("Table unchanged since serial %d\n", seen)
+				return h.reply(request, response)
+			}
+		}
+	}
+	if len(request.Question) == 1 && request.Question[0].Qclass != dns.ClassINET {
+		q := request.Question[0]
+		if answer, found := h.chaosTXT(q); found {
+			response.Answer = answer
+			return h.reply(request, response)
+		}
+		// only the Internet class is served
+		log.Printf("Query class %s not supported: %s\n", dns.ClassToString[q.Qclass], h.logName(q.Name))
+		response.Rcode = h.classRcode
+		return h.reply(request, response)
+	}
+	if len(request.Question) == 1 {
+		if q := request.Question[0]; q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+			if !h.allowTransfer {
+				log.Printf("Refusing zone transfer of %s\n", h.logName(q.Name))
+				response.Rcode = dns.RcodeRefused
+				return h.reply(request, response)
+			}
+			log.Printf("Transferring zone %s\n", h.logName(q.Name))
+			response.Answer = h.transfer(q.Name)
+			return h.reply(request, response)
+		}
+	}
+	var asked string
+	if h.malformedNames != malformedOff {
+		if name, found := stripSchemePort(request.Question[0].Name); found {
+			if h.malformedNames == malformedFormerr {
+				log.Printf("Rejecting query name with a scheme or port: %s\n", h.logName(request.Question[0].Name))
+				response.Rcode = dns.RcodeFormatError
+				return h.reply(request, response)
+			}
+			log.Printf("Answering %s for %s\n", h.logName(name), h.logName(request.Question[0].Name))
+			asked = request.Question[0].Name
+			request.Question[0].Name = name
+		}
+	}
+	nodata := false
+	servfail := false
 	for _, q := range request.Question {
+		if h.qtypes != nil && !h.qtypes[q.Qtype] {
+			log.Printf("Query type %s is disabled: %s\n", dns.TypeToString[q.Qtype], h.logName(q.Name))
+			nodata = true
+			continue
+		}
+		if answer, found := h.versionTXT(q); found {
+			response.Answer = answer
+			continue
+		}
+		if answer, found := h.nameserverAnswer(q); found {
+			log.Printf("Answering nameserver name %s\n", h.logName(q.Name))
+			response.Answer = answer
+			nodata = nodata || len(answer) == 0
+			continue
+		}
+		if rcode, found := h.reserved.match(q.Name); found {
+			log.Printf("Answering reserved name %s with %s\n", h.logName(q.Name), dns.RcodeToString[rcode])
+			response.Answer = nil
+			response.Rcode = rcode
+			return h.reply(request, response)
+		}
+		if h.specialNames {
+			if answer, handled, empty := answerSpecialName(q); handled {
+				log.Printf("Answering special-use name %s\n", h.logName(q.Name))
+				response.Answer = answer
+				nodata = nodata || empty
+				continue
+			}
+		}
+		if entry := v.lookup(q.Name); entry != nil && entry.decommissioned {
+			log.Printf("Host %s is decommissioned\n", h.logName(q.Name))
+			response.Answer = nil
+			response.Ns = []dns.RR{h.negativeSOA(q.Name, h.decommissionTTL)}
+			response.Rcode = dns.RcodeNameError
+			return h.reply(request, response)
+		}
+		static, staticName := h.static.answer(q)
+		if len(static) > 0 && !h.tablePrecedence {
+			log.Printf("Answering static records of %s\n", h.logName(q.Name))
+			response.Answer = static
+			continue
+		}
 		switch q.Qtype {
-		case dns.TypeA:
-			var vips []net.IP
-			log.Printf("Query A record: %s->%v\n", q.Name, q)
-			h.mapMutex.RLock()
-			//log.Printf("DNS map: %v\n", h.dnsEntries)
-			if h.dnsEntries != nil {
-				vips = h.dnsEntries[q.Name]
-				if vips == nil {
-					// check for wildcard format
-					// Split name into pieces by . (remember that DNS queries have dot in the end as well)
-					// Check for each smaller variant of the name, until we have
-					pieces := strings.Split(q.Name, ".")
-					pieces = pieces[1:]
-					for ; len(pieces) > 2; pieces = pieces[1:] {
-						if vips = h.dnsEntries[fmt.Sprintf(".%s", strings.Join(pieces, "."))]; vips != nil {
-							break
+		case dns.TypeA, dns.TypeAAAA, dns.TypeANY:
+			log.Printf("Query %s record: %s\n", dns.TypeToString[q.Qtype], h.logName(q.Name))
+			key := h.answerKey(request, client, q)
+			if answer, found := v.answers.get(key, time.Now()); found {
+				log.Printf("Found %s in the answer cache\n", h.logName(q.Name))
+				response.Answer = answer
+			} else if cached, found := v.negatives.lookup(key, time.Now()); found {
+				log.Printf("Found %s in the negative answer cache\n", h.logName(q.Name))
+				nodata = nodata || cached.nodata
+			} else if entry := h.lookupAddresses(v, q.Name); entry != nil && h.visible(request, entry) {
+				log.Printf("Found %s->%v\n", h.logName(q.Name), entry)
+				if entry.noEndpoints && h.emptyInternal == emptySERVFAIL {
+					log.Printf("No endpoints for %s\n", h.logName(q.Name))
+					servfail = true
+				} else if entry.cname != "" {
+					response.Answer = cname(q.Name, entry.cname, h.entryAnswerTTL(entry, q.Name, dns.TypeCNAME, []string{entry.cname}))
+					if entry.chase {
+						chased, err := h.chase(ctx, v, entry.cname, q.Qtype)
+						if err != nil && ctx.Err() == nil {
+							log.Printf("Failed to chase CNAME of %s: %v\n", h.logName(q.Name), err)
+							servfail = true
+						}
+						response.Answer = append(response.Answer, chased...)
+						if n := len(chased); n > 0 && chased[n-1].Header().Rrtype != dns.TypeCNAME {
+							v.answers.put(key, response.Answer, time.Now())
 						}
+					} else {
+						v.answers.put(key, response.Answer, time.Now())
+					}
+				} else {
+					vips := entry.vips
+					if h.tenantLabel != "" {
+						// a tenant without endpoints of the host gets its default answer
+						if tenant, found := requestTenant(request, h.tenantOption); found && len(entry.tenants[tenant]) > 0 {
+							vips = entry.tenants[tenant]
+						}
+					}
+					stable := !entry.ordered && (h.stableAnswers || entry.stable)
+					switch {
+					case entry.ordered:
+						// primary first, exactly as listed
+					case stable:
+						vips = sortedIPs(vips)
+					case h.rfc6724 && client != nil:
+						vips = sortRFC6724(vips, client)
+					}
+					if !entry.ordered {
+						vips = orderFamilies(vips, h.familyOrder)
+					}
+					response.Answer = addressRecords(q.Name, q.Qtype, vips, h.entryAnswerTTL(entry, q.Name, q.Qtype, ipStrings(vips)))
+					rotated := false
+					all := response.Answer
+					if limit := h.answerCap(entry); entry.ordered && limit > 0 && len(response.Answer) > limit {
+						response.Answer = response.Answer[:limit]
+					} else if !stable && !entry.ordered {
+						response.Answer = capAnswers(h.logName(q.Name), entry, response.Answer, h.answerCap(entry))
+						rotated = len(response.Answer) < len(all)
+					}
+					if h.bothFamilies && len(response.Answer) < len(all) {
+						response.Answer = keepFamilies(all, response.Answer)
+					}
+					if !rotated {
+						// capped answers rotate with every query
+						v.answers.put(key, response.Answer, time.Now())
+					}
+					if len(response.Answer) == 0 {
+						// the host exists, without addresses of the queried family
+						nodata = true
+						v.negatives.putNegative(key, true, time.Now())
 					}
 				}
+			} else {
+				v.negatives.putNegative(key, false, time.Now())
+			}
+		case dns.TypeCNAME:
+			log.Printf("Query CNAME record: %s\n", h.logName(q.Name))
+			if entry := v.lookup(q.Name); entry != nil && entry.cname != "" && h.visible(request, entry) {
+				log.Printf("Found %s->%v\n", h.logName(q.Name), entry)
+				response.Answer = cname(q.Name, entry.cname, h.entryAnswerTTL(entry, q.Name, dns.TypeCNAME, []string{entry.cname}))
 			}
-			h.mapMutex.RUnlock()
-			if vips != nil {
-				log.Printf("Found %s->%v\n", q.Name, vips)
-				response.Answer = a(q.Name, vips)
+		case dns.TypePTR:
+			var names []string
+			log.Printf("Query PTR record: %s\n", h.logName(q.Name))
+			if v.ptrEntries != nil {
+				names = v.ptrEntries[strings.ToLower(q.Name)]
+			}
+			if names != nil {
+				log.Printf("Found %s->%v\n", h.logName(q.Name), names)
+				response.Answer = ptr(q.Name, names, h.answerTTL(q.Name, h.typeTTL(dns.TypePTR), names))
 			}
 			//default:
 			//	log.Printf("Unknown query type: %v\n", q)
 		}
+		if len(response.Answer) == 0 && staticName {
+			// the table has nothing for the name, the static records may
+			response.Answer = static
+			nodata = nodata || len(static) == 0
+		} else if len(response.Answer) > 0 {
+			h.stats.record(h.logName(v.statsHost(q.Name)), q.Qtype)
+		}
 	}
-	if len(response.Answer) == 0 {
+	if err := abandoned(ctx); err != nil {
+		return nil, err
+	}
+	if asked != "" {
+		restoreOwner(response.Answer, request.Question[0].Name, asked)
+	}
+	if servfail {
+		response.Answer = nil
+		response.Rcode = dns.RcodeServerFailure
+	} else if len(response.Answer) == 0 && !nodata && v.nonTerminal(request.Question) {
+		log.Printf("No records of empty non-terminal %s\n", h.logName(request.Question[0].Name))
+		response.Ns = []dns.RR{h.negativeSOA(request.Question[0].Name, soaMinimum)}
+	} else if len(response.Answer) == 0 && !nodata {
 		log.Println("Could not find the service requested")
 		response.Rcode = dns.RcodeNameError
+		response.Ns = []dns.RR{h.negativeSOA(request.Question[0].Name, soaMinimum)}
+	} else if len(response.Answer) == 0 {
+		// NODATA, cached by resolvers for the SOA's negative TTL (RFC 2308)
+		response.Ns = []dns.RR{h.negativeSOA(request.Question[0].Name, soaMinimum)}
 	}
 
+	observeAnswer(response)
+	return h.reply(request, response)
+}
+
+// pack marshals the response into the gRPC reply.
+func pack(response *dns.Msg) (*dnsapi.DnsPacket, error) {
 	out, err := response.Pack()
 	if err != nil {
 		return nil, fmt.Errorf("failed to mashall dns response: %v", err)
@@ -197,24 +1285,120 @@ func (h *IstioServiceEntries) Query(ctx context.Context, in *dnsapi.DnsPacket) (
 	return &dnsapi.DnsPacket{Msg: out}, nil
 }
 
+// lookup returns the table entry for name, falling back to the closest,
+// i.e. longest, wildcard entry, then to an empty entry for unknown subsets
+// of hosts with subsets, or nil if there is none. Names are compared case
+// insensitively; answers are built with the name as asked, so that the
+// case randomization (0x20) of resolvers is echoed unchanged.
+func (v tableView) lookup(name string) *dnsEntry {
+	name = strings.ToLower(dns.Fqdn(name))
+	//log.Printf("DNS map: %v\n", v.dnsEntries)
+	if v.dnsEntries == nil {
+		return nil
+	}
+	if entry := v.dnsEntries[name]; entry != nil {
+		return entry
+	}
+	// check for wildcard format
+	if key := v.wildcardKey(name); key != "" {
+		return v.dnsEntries[key]
+	}
+	if parts := strings.SplitN(name, ".", 2); len(parts) == 2 {
+		if parent := v.dnsEntries[parts[1]]; parent != nil && parent.hasSubsets {
+			// unknown subset of a known host not covered by a wildcard:
+			// the name exists, without records
+			return &dnsEntry{}
+		}
+	}
+	return nil
+}
+
+// wildcardKey returns the key of the wildcard entry matching the lower case
+// FQDN name, or "" if none does.
+func (v tableView) wildcardKey(name string) string {
+	// Split name into pieces by . (remember that DNS queries have dot in the end as well)
+	// Check for each smaller variant of the name, until we have
+	pieces := strings.Split(name, ".")
+	pieces = pieces[1:]
+	for ; len(pieces) > 2; pieces = pieces[1:] {
+		key := fmt.Sprintf(".%s", strings.Join(pieces, "."))
+		if v.dnsEntries[key] != nil {
+			return key
+		}
+	}
+	return ""
+}
+
 // Name implements the plugin.Handle interface.
 func (h *IstioServiceEntries) Name() string { return "istio" }
 
 // a takes a slice of net.IPs and returns a slice of A RRs.
-func a(zone string, ips []net.IP) []dns.RR {
+func a(zone string, ips []net.IP, ttl uint32) []dns.RR {
 	answers := []dns.RR{}
 	for _, ip := range ips {
 		r := new(dns.A)
 		r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeA,
-			Class: dns.ClassINET, Ttl: 3600}
+			Class: dns.ClassINET, Ttl: ttl}
 		r.A = ip
 		answers = append(answers, r)
 	}
 	return answers
 }
 
-func NewIstioHandle(kubeconfig string, context string) (*IstioServiceEntries, error) {
-	var h = &IstioServiceEntries{}
+// addressRecords returns A records for the IPv4 and AAAA records for the IPv6
+// addresses in ips, as asked for by qtype (A, AAAA or ANY for both).
+func addressRecords(zone string, qtype uint16, ips []net.IP, ttl uint32) []dns.RR {
+	answers := []dns.RR{}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			if qtype == dns.TypeA || qtype == dns.TypeANY {
+				answers = append(answers, a(zone, []net.IP{ip4}, ttl)...)
+			}
+		} else if qtype == dns.TypeAAAA || qtype == dns.TypeANY {
+			r := new(dns.AAAA)
+			r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeAAAA,
+				Class: dns.ClassINET, Ttl: ttl}
+			r.AAAA = ip
+			answers = append(answers, r)
+		}
+	}
+	return answers
+}
+
+// ipStrings returns the textual form of each IP.
+func ipStrings(ips []net.IP) []string {
+	out := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		out = append(out, ip.String())
+	}
+	return out
+}
+
+// cname returns a single CNAME RR aliasing zone to target.
+func cname(zone string, target string, ttl uint32) []dns.RR {
+	r := new(dns.CNAME)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeCNAME,
+		Class: dns.ClassINET, Ttl: ttl}
+	r.Target = target
+	return []dns.RR{r}
+}
+
+// ptr takes a slice of host names and returns a slice of PTR RRs.
+func ptr(zone string, names []string, ttl uint32) []dns.RR {
+	answers := []dns.RR{}
+	for _, name := range names {
+		r := new(dns.PTR)
+		r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypePTR,
+			Class: dns.ClassINET, Ttl: ttl}
+		r.Ptr = name
+		answers = append(answers, r)
+	}
+	return answers
+}
+
+func NewIstioHandle(kubeconfig string, context string, subsets bool) (*IstioServiceEntries, error) {
+	// stop is closed on shutdown; everything started with it stops then
+	var h = &IstioServiceEntries{subsets: subsets, stop: make(chan struct{})}
 	istioControllerOptions := kube.ControllerOptions{
 		WatchedNamespace: "",
 		ResyncPeriod:     60 * time.Second,
@@ -223,6 +1407,9 @@ func NewIstioHandle(kubeconfig string, context string) (*IstioServiceEntries, er
 	descriptors := model.ConfigDescriptor{
 		model.ServiceEntry,
 	}
+	if subsets {
+		descriptors = append(descriptors, model.DestinationRule)
+	}
 	configClient, err := crd.NewClient(kubeconfig, context, descriptors, istioControllerOptions.DomainSuffix)
 
 	if err != nil {
@@ -232,5 +1419,6 @@ func NewIstioHandle(kubeconfig string, context string) (*IstioServiceEntries, er
 	configController := crd.NewController(configClient, istioControllerOptions)
 	go configController.Run(h.stop)
 	h.configStore = model.MakeIstioStore(configController)
+	h.synced = configController.HasSynced
 	return h, nil
 }
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+
+	dnsapi "github.com/istio-ecosystem/istio-coredns-plugin/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestHandle returns a handle answering from the given tables.
+func newTestHandle(dnsEntries map[string]*dnsEntry, ptrEntries map[string][]string) *IstioServiceEntries {
+	return &IstioServiceEntries{
+		dnsEntries:      dnsEntries,
+		ptrEntries:      ptrEntries,
+		serveBeforeSync: true,
+		stop:            make(chan struct{}),
+	}
+}
+
+// newStoreHandle returns a handle reading the given service entries from a
+// synced in-memory config store, which readServiceEntries has not read yet.
+func newStoreHandle(t *testing.T, configs ...model.Config) *IstioServiceEntries {
+	t.Helper()
+	h := newTestHandle(nil, nil)
+	h.configStore = model.MakeIstioStore(memory.Make(model.ConfigDescriptor{model.ServiceEntry}))
+	h.synced = func() bool { return true }
+	for _, config := range configs {
+		if _, err := h.configStore.Create(config); err != nil {
+			t.Fatalf("failed to create %s: %v", config.Name, err)
+		}
+	}
+	return h
+}
+
+// serviceEntry returns the config of a service entry in the default
+// namespace.
+func serviceEntry(name string, annotations map[string]string, spec *networking.ServiceEntry) model.Config {
+	return model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:        model.ServiceEntry.Type,
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: spec,
+	}
+}
+
+// exchange sends request to h and returns the response.
+func exchange(t *testing.T, h *IstioServiceEntries, request *dns.Msg) *dns.Msg {
+	t.Helper()
+	in, err := request.Pack()
+	if err != nil {
+		t.Fatalf("failed to pack request: %v", err)
+	}
+	out, err := h.Query(context.Background(), &dnsapi.DnsPacket{Msg: in})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	response := new(dns.Msg)
+	if err := response.Unpack(out.Msg); err != nil {
+		t.Fatalf("failed to unpack response: %v", err)
+	}
+	return response
+}
+
+// query asks h for name and qtype.
+func query(t *testing.T, h *IstioServiceEntries, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	return exchange(t, h, new(dns.Msg).SetQuestion(name, qtype))
+}
+
+// rdata returns the data of the records, without their headers.
+func rdata(records []dns.RR) []string {
+	var data []string
+	for _, rr := range records {
+		switch r := rr.(type) {
+		case *dns.A:
+			data = append(data, r.A.String())
+		case *dns.AAAA:
+			data = append(data, r.AAAA.String())
+		case *dns.CNAME:
+			data = append(data, r.Target)
+		case *dns.PTR:
+			data = append(data, r.Ptr)
+		case *dns.TXT:
+			data = append(data, r.Txt...)
+		default:
+			data = append(data, rr.String())
+		}
+	}
+	return data
+}
+
+// negativeZone returns the owner of the SOA in the authority section of the
+// response, or "" if there is none.
+func negativeZone(response *dns.Msg) string {
+	if len(response.Ns) != 1 {
+		return ""
+	}
+	if soa, ok := response.Ns[0].(*dns.SOA); ok {
+		return soa.Hdr.Name
+	}
+	return ""
+}
+
+// ips parses the addresses.
+func ips(addresses ...string) []net.IP {
+	parsed := make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		parsed = append(parsed, net.ParseIP(address))
+	}
+	return parsed
+}
+
+func TestConvertToVIPs(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []string
+		want      []string
+	}{
+		{name: "addresses", addresses: []string{"10.0.0.1", "2001:db8::1"}, want: []string{"10.0.0.1", "2001:db8::1"}},
+		{name: "full mask", addresses: []string{"10.0.0.1/32", "2001:db8::1/128"}, want: []string{"10.0.0.1", "2001:db8::1"}},
+		{name: "network", addresses: []string{"10.0.0.0/24", "2001:db8::/64"}},
+		{name: "invalid", addresses: []string{"foo", "10.0.0.1/33"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ipStrings(convertToVIPs(tt.addresses))
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertToVIPs(%v) = %v, want %v", tt.addresses, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseLookup(t *testing.T) {
+	ptrEntries := make(map[string][]string)
+	addReverseEntries(ptrEntries, "foo.com.", ips("10.0.0.1", "2001:db8::1"))
+	addReverseEntries(ptrEntries, "bar.com.", ips("10.0.0.1"))
+	addReverseEntries(ptrEntries, "bar.com.", ips("10.0.0.1"))
+	h := newTestHandle(map[string]*dnsEntry{}, ptrEntries)
+
+	tests := []struct {
+		name  string
+		rcode int
+		want  []string
+	}{
+		{name: "1.0.0.10.in-addr.arpa.", want: []string{"foo.com.", "bar.com."}},
+		{name: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", want: []string{"foo.com."}},
+		{name: "2.0.0.10.in-addr.arpa.", rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := query(t, h, tt.name, dns.TypePTR)
+			if response.Rcode != tt.rcode {
+				t.Fatalf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetainRemoved(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    []string
+	}{
+		{name: "just removed", elapsed: 0, want: []string{"gone.com."}},
+		{name: "within grace", elapsed: time.Minute, want: []string{"gone.com."}},
+		{name: "past grace", elapsed: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{
+				"kept.com.": {vips: ips("10.0.0.1")},
+				"gone.com.": {vips: ips("10.0.0.2")},
+			}, nil)
+			h.removalGrace = 5 * time.Minute
+			// the host disappears at start and is still gone after elapsed
+			h.retainRemoved(map[string]*dnsEntry{"kept.com.": {vips: ips("10.0.0.1")}}, start)
+			dnsEntries := map[string]*dnsEntry{"kept.com.": {vips: ips("10.0.0.1")}}
+			got := h.retainRemoved(dnsEntries, start.Add(tt.elapsed))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("retainRemoved() = %v, want %v", got, tt.want)
+			}
+			if _, found := dnsEntries["gone.com."]; found != (len(tt.want) > 0) {
+				t.Errorf("gone.com. in table: %v, want %v", found, len(tt.want) > 0)
+			}
+		})
+	}
+}
+
+func TestRetainReverseEntries(t *testing.T) {
+	previous := map[string][]string{
+		"1.0.0.10.in-addr.arpa.": {"gone.com.", "kept.com."},
+		"2.0.0.10.in-addr.arpa.": {"gone.com."},
+		"3.0.0.10.in-addr.arpa.": {"other.com."},
+	}
+	ptrEntries := map[string][]string{"1.0.0.10.in-addr.arpa.": {"kept.com."}}
+	retainReverseEntries(ptrEntries, previous, "gone.com.")
+	want := map[string][]string{
+		"1.0.0.10.in-addr.arpa.": {"kept.com.", "gone.com."},
+		"2.0.0.10.in-addr.arpa.": {"gone.com."},
+	}
+	if !reflect.DeepEqual(ptrEntries, want) {
+		t.Errorf("reverse entries = %v, want %v", ptrEntries, want)
+	}
+}
+
+func TestQtypes(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, map[string][]string{"1.0.0.10.in-addr.arpa.": {"foo.com."}})
+	var err error
+	if h.qtypes, err = parseQtypes(" a , ptr"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseQtypes("A,NOPE"); err == nil {
+		t.Error("parseQtypes() accepted an unknown type")
+	}
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  int
+		soa   string
+	}{
+		{name: "foo.com.", qtype: dns.TypeA, want: 1},
+		{name: "1.0.0.10.in-addr.arpa.", qtype: dns.TypePTR, want: 1},
+		// disabled types get NODATA with the SOA of the zone
+		{name: "foo.com.", qtype: dns.TypeAAAA, soa: "foo.com."},
+		{name: "foo.com.", qtype: dns.TypeANY, soa: "foo.com."},
+	}
+	for _, tt := range tests {
+		t.Run(dns.TypeToString[tt.qtype], func(t *testing.T) {
+			response := query(t, h, tt.name, tt.qtype)
+			if response.Rcode != dns.RcodeSuccess || len(response.Answer) != tt.want {
+				t.Errorf("rcode %s with %d records, want NOERROR with %d", dns.RcodeToString[response.Rcode], len(response.Answer), tt.want)
+			}
+			if got := negativeZone(response); got != tt.soa {
+				t.Errorf("SOA of %q, want %q", got, tt.soa)
+			}
+		})
+	}
+}
+
+func TestDrainingHosts(t *testing.T) {
+	port := []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}}
+	draining := map[string]string{drainAnnotation: "true"}
+	h := newStoreHandle(t,
+		serviceEntry("serving", nil, &networking.ServiceEntry{
+			Hosts: []string{"serving.com"}, Addresses: []string{"10.0.0.1"}, Ports: port,
+			Resolution: networking.ServiceEntry_STATIC, Endpoints: []*networking.ServiceEntry_Endpoint{{Address: "192.0.2.1"}},
+		}),
+		serviceEntry("draining", draining, &networking.ServiceEntry{
+			Hosts: []string{"draining.com"}, Addresses: []string{"10.0.0.2"}, Ports: port,
+			Resolution: networking.ServiceEntry_STATIC, Endpoints: []*networking.ServiceEntry_Endpoint{{Address: "192.0.2.2"}},
+		}),
+		serviceEntry("drained-vip", draining, &networking.ServiceEntry{
+			Hosts: []string{"drained-vip.com"}, Addresses: []string{"10.0.0.3"}, Ports: port,
+			Resolution: networking.ServiceEntry_DNS,
+		}),
+		serviceEntry("drained", draining, &networking.ServiceEntry{
+			Hosts: []string{"drained.com"}, Ports: port,
+			Resolution: networking.ServiceEntry_DNS,
+		}),
+	)
+	h.drainTTL = 5
+	// the drain TTL holds below --min-ttl
+	h.minTTL = 60
+	h.readServiceEntries("")
+	tests := []struct {
+		name  string
+		rcode int
+		want  []string
+		ttl   uint32
+	}{
+		{name: "serving.com.", want: []string{"10.0.0.1"}, ttl: defaultTTL},
+		{name: "draining.com.", want: []string{"10.0.0.2"}, ttl: 5},
+		// addresses are served with the drain TTL without endpoints too
+		{name: "drained-vip.com.", want: []string{"10.0.0.3"}, ttl: 5},
+		// known without records once the endpoints are gone
+		{name: "drained.com."},
+		{name: "unknown.com.", rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := query(t, h, tt.name, dns.TypeA)
+			if response.Rcode != tt.rcode {
+				t.Fatalf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+			for _, rr := range response.Answer {
+				if rr.Header().Ttl != tt.ttl {
+					t.Errorf("TTL = %d, want %d", rr.Header().Ttl, tt.ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestWildcardAddress(t *testing.T) {
+	wildcard := serviceEntry("wildcard", nil, &networking.ServiceEntry{
+		Hosts:      []string{"*.wild.com"},
+		Ports:      []*networking.Port{{Number: 443, Name: "https", Protocol: "HTTPS"}},
+		Resolution: networking.ServiceEntry_STATIC,
+		Endpoints:  []*networking.ServiceEntry_Endpoint{{Address: "192.0.2.1"}},
+	})
+	tests := []struct {
+		name            string
+		wildcardAddress string
+		want            []string
+	}{
+		{name: "wildcard address", wildcardAddress: "10.0.0.1", want: []string{"10.0.0.1"}},
+		{name: "no wildcard address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newStoreHandle(t, wildcard)
+			h.wildcardAddress = net.ParseIP(tt.wildcardAddress)
+			h.readServiceEntries("")
+			response := query(t, h, "foo.wild.com.", dns.TypeA)
+			if response.Rcode != dns.RcodeSuccess {
+				t.Fatalf("rcode = %s, want NOERROR", dns.RcodeToString[response.Rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaseRandomization(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		"foo.com.":   {vips: ips("10.0.0.1")},
+		".wild.com.": {vips: ips("10.0.0.2")},
+	}, nil)
+	h.ttlJitter = 50
+	for _, name := range []string{"fOo.CoM.", "BaR.wIlD.cOm."} {
+		t.Run(name, func(t *testing.T) {
+			lower := query(t, h, strings.ToLower(name), dns.TypeA)
+			response := query(t, h, name, dns.TypeA)
+			if response.Question[0].Name != name {
+				t.Errorf("question = %s, want %s", response.Question[0].Name, name)
+			}
+			if len(response.Answer) != 1 || response.Answer[0].Header().Name != name {
+				t.Fatalf("answer = %v, want one record owned by %s", response.Answer, name)
+			}
+			// the jittered TTL does not depend on the case
+			if got, want := response.Answer[0].Header().Ttl, lower.Answer[0].Header().Ttl; got != want {
+				t.Errorf("TTL = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxStreams uint32
+		maxRecv    int
+		maxSend    int
+		want       int
+	}{
+		{name: "defaults", want: 0},
+		{name: "streams", maxStreams: 100, want: 1},
+		{name: "all", maxStreams: 100, maxRecv: 1 << 20, maxSend: 1 << 20, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(serverOptions(tt.maxStreams, tt.maxRecv, tt.maxSend)); got != tt.want {
+				t.Errorf("serverOptions() returned %d options, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerMessageSizes(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		"small.com.": {vips: ips("10.0.0.1")},
+		"large.com.": {vips: ips("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7", "10.0.0.8",
+			"10.0.0.9", "10.0.0.10", "10.0.0.11", "10.0.0.12", "10.0.0.13", "10.0.0.14", "10.0.0.15", "10.0.0.16")},
+	}, nil)
+	// a query of about 230 bytes, and answers of about 40 and 280 bytes
+	long := strings.Repeat(strings.Repeat("a", 60)+".", 3) + "small.com."
+	tests := []struct {
+		name    string
+		maxRecv int
+		maxSend int
+		qname   string
+		want    codes.Code
+	}{
+		{name: "defaults", qname: "large.com.", want: codes.OK},
+		{name: "query within max-recv", maxRecv: 200, qname: "small.com.", want: codes.OK},
+		{name: "query over max-recv", maxRecv: 200, qname: long, want: codes.ResourceExhausted},
+		{name: "answer not limited by max-recv", maxRecv: 200, qname: "large.com.", want: codes.OK},
+		{name: "answer within max-send", maxSend: 200, qname: "small.com.", want: codes.OK},
+		{name: "answer over max-send", maxSend: 200, qname: "large.com.", want: codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := newGRPCServer(h, false, serverOptions(0, tt.maxRecv, tt.maxSend)...)
+			go server.Serve(listener)
+			defer server.Stop()
+			conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			in, err := new(dns.Msg).SetQuestion(tt.qname, dns.TypeA).Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = dnsapi.NewDnsServiceClient(conn).Query(context.Background(), &dnsapi.DnsPacket{Msg: in})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %v, want %v: %v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestBatchQuery(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		"foo.com.": {vips: ips("10.0.0.1")},
+		"bar.com.": {vips: ips("10.0.0.2")},
+	}, nil)
+	pack := func(name string) *dnsapi.DnsPacket {
+		msg, err := new(dns.Msg).SetQuestion(name, dns.TypeA).Pack()
+		if err != nil {
+			t.Fatalf("failed to pack request: %v", err)
+		}
+		return &dnsapi.DnsPacket{Msg: msg}
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		packets []*dnsapi.DnsPacket
+		want    [][]string
+		wantErr bool
+	}{
+		{name: "empty", ctx: context.Background()},
+		{
+			name:    "in order",
+			ctx:     context.Background(),
+			packets: []*dnsapi.DnsPacket{pack("bar.com."), pack("foo.com."), pack("baz.com.")},
+			want:    [][]string{{"10.0.0.2"}, {"10.0.0.1"}, nil},
+		},
+		{
+			name:    "malformed packet",
+			ctx:     context.Background(),
+			packets: []*dnsapi.DnsPacket{pack("foo.com."), {Msg: []byte{1}}},
+			wantErr: true,
+		},
+		{
+			name:    "cancelled",
+			ctx:     cancelled,
+			packets: []*dnsapi.DnsPacket{pack("foo.com.")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := h.BatchQuery(tt.ctx, &dnsapi.DnsBatch{Packets: tt.packets})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BatchQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(out.Packets) != len(tt.want) {
+				t.Fatalf("got %d replies, want %d", len(out.Packets), len(tt.want))
+			}
+			for i, packet := range out.Packets {
+				response := new(dns.Msg)
+				if err := response.Unpack(packet.Msg); err != nil {
+					t.Fatalf("failed to unpack reply %d: %v", i, err)
+				}
+				if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("reply %d = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMultipleQuestions(t *testing.T) {
+	tests := []struct {
+		name          string
+		firstQuestion bool
+		rcode         int
+		want          []string
+	}{
+		{name: "rejected", rcode: dns.RcodeFormatError},
+		{name: "first answered", firstQuestion: true, want: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{
+				"foo.com.": {vips: ips("10.0.0.1")},
+				"bar.com.": {vips: ips("10.0.0.2")},
+			}, nil)
+			h.firstQuestion = tt.firstQuestion
+			request := new(dns.Msg).SetQuestion("foo.com.", dns.TypeA)
+			request.Question = append(request.Question, dns.Question{Name: "bar.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+			response := exchange(t, h, request)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableKey(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "foo.com", want: "foo.com."},
+		{host: "foo.com.", want: "foo.com."},
+		{host: "Foo.COM", want: "foo.com."},
+		{host: "*.wild.com", want: ".wild.com."},
+		{host: "*.Wild.com.", want: ".wild.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := tableKey(tt.host); got != tt.want {
+				t.Errorf("tableKey(%s) = %s, want %s", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupCanonicalName(t *testing.T) {
+	v := tableView{dnsEntries: map[string]*dnsEntry{
+		"foo.com.":   {vips: ips("10.0.0.1")},
+		".wild.com.": {vips: ips("10.0.0.2")},
+	}}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "foo.com.", want: []string{"10.0.0.1"}},
+		{name: "foo.com", want: []string{"10.0.0.1"}},
+		{name: "FOO.com", want: []string{"10.0.0.1"}},
+		{name: "a.wild.com", want: []string{"10.0.0.2"}},
+		{name: "bar.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			if entry := v.lookup(tt.name); entry != nil {
+				for _, ip := range entry.vips {
+					got = append(got, ip.String())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup(%s) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecommissioned(t *testing.T) {
+	h := newStoreHandle(t, serviceEntry("old", map[string]string{decommissionAnnotation: "true"}, &networking.ServiceEntry{
+		Hosts: []string{"old.svc.example.com"}, Addresses: []string{"10.0.0.1"},
+		Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+		Resolution: networking.ServiceEntry_DNS,
+	}))
+	h.decommissionTTL = 86400
+	h.zones = parseZones("example.com")
+	h.readServiceEntries("")
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeTXT} {
+		t.Run(dns.TypeToString[qtype], func(t *testing.T) {
+			response := query(t, h, "Old.svc.example.com.", qtype)
+			if response.Rcode != dns.RcodeNameError || len(response.Answer) != 0 {
+				t.Errorf("rcode = %s, answer = %v, want NXDOMAIN without records", dns.RcodeToString[response.Rcode], response.Answer)
+			}
+			if len(response.Ns) != 1 {
+				t.Fatalf("authority = %v, want one SOA", response.Ns)
+			}
+			soa, ok := response.Ns[0].(*dns.SOA)
+			if !ok || soa.Hdr.Name != "example.com." || soa.Hdr.Ttl != 86400 || soa.Minttl != 86400 {
+				t.Errorf("authority = %v, want the SOA of example.com. with a TTL of 86400", response.Ns[0])
+			}
+		})
+	}
+}
+
+func TestTruncatedQuery(t *testing.T) {
+	tests := []struct {
+		name            string
+		truncated       bool
+		rejectTruncated bool
+		rcode           int
+		want            []string
+	}{
+		{name: "clear", want: []string{"10.0.0.1"}},
+		{name: "ignored", truncated: true, want: []string{"10.0.0.1"}},
+		{name: "rejected", truncated: true, rejectTruncated: true, rcode: dns.RcodeFormatError},
+		{name: "clear with formerr", rejectTruncated: true, want: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.rejectTruncated = tt.rejectTruncated
+			request := new(dns.Msg).SetQuestion("foo.com.", dns.TypeA)
+			request.Truncated = tt.truncated
+			response := exchange(t, h, request)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if response.Truncated {
+				t.Errorf("response has the TC bit set")
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyQuestion(t *testing.T) {
+	for _, rcode := range []int{dns.RcodeFormatError, dns.RcodeRefused} {
+		t.Run(dns.RcodeToString[rcode], func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.emptyRcode = rcode
+			request := new(dns.Msg)
+			request.Id = dns.Id()
+			response := exchange(t, h, request)
+			if response.Rcode != rcode || response.Id != request.Id || len(response.Answer) != 0 {
+				t.Errorf("response = %v, want %s for query %d", response, dns.RcodeToString[rcode], request.Id)
+			}
+		})
+	}
+}
+
+func TestOverlappingWildcards(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		".example.com.":      {vips: ips("10.0.0.1")},
+		".svc.example.com.":  {vips: ips("10.0.0.2")},
+		"a.svc.example.com.": {vips: ips("10.0.0.3")},
+	}, nil)
+	tests := []struct {
+		qname string
+		rcode int
+		want  []string
+	}{
+		{qname: "foo.example.com.", want: []string{"10.0.0.1"}},
+		{qname: "foo.svc.example.com.", want: []string{"10.0.0.2"}},
+		{qname: "foo.bar.svc.example.com.", want: []string{"10.0.0.2"}},
+		{qname: "a.svc.example.com.", want: []string{"10.0.0.3"}},
+		{qname: "b.a.svc.example.com.", want: []string{"10.0.0.2"}},
+		{qname: "example.com.", rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.qname, func(t *testing.T) {
+			response := query(t, h, tt.qname, dns.TypeA)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCReflection(t *testing.T) {
+	tests := []struct {
+		name             string
+		enableReflection bool
+		want             []string
+	}{
+		{name: "disabled", want: []string{"coredns.dns.DnsService"}},
+		{name: "enabled", enableReflection: true, want: []string{"coredns.dns.DnsService", "grpc.reflection.v1alpha.ServerReflection"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for service := range newGRPCServer(newTestHandle(nil, nil), tt.enableReflection).GetServiceInfo() {
+				got = append(got, service)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("services = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegativeAnswers(t *testing.T) {
+	h := newStoreHandle(t,
+		serviceEntry("foo", nil, &networking.ServiceEntry{
+			Hosts:      []string{"foo.mesh.internal"},
+			Addresses:  []string{"10.0.0.1"},
+			Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+			Resolution: networking.ServiceEntry_DNS,
+		}),
+		serviceEntry("empty", nil, &networking.ServiceEntry{
+			Hosts:      []string{"empty.mesh.internal"},
+			Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+			Location:   networking.ServiceEntry_MESH_INTERNAL,
+			Resolution: networking.ServiceEntry_DNS,
+		}),
+	)
+	h.zones = parseZones("mesh.internal")
+	h.emptyInternal = emptyNODATA
+	h.qtypes = map[uint16]bool{dns.TypeA: true, dns.TypeAAAA: true}
+	h.readServiceEntries("")
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		rcode int
+		soa   string
+	}{
+		{name: "answer", qname: "foo.mesh.internal.", qtype: dns.TypeA},
+		{name: "unknown host", qname: "bar.mesh.internal.", qtype: dns.TypeA, rcode: dns.RcodeNameError, soa: "mesh.internal."},
+		{name: "outside the zones", qname: "bar.example.com.", qtype: dns.TypeA, rcode: dns.RcodeNameError, soa: "example.com."},
+		{name: "disabled type", qname: "foo.mesh.internal.", qtype: dns.TypeTXT, soa: "mesh.internal."},
+		{name: "other family", qname: "foo.mesh.internal.", qtype: dns.TypeAAAA, soa: "mesh.internal."},
+		{name: "without endpoints", qname: "empty.mesh.internal.", qtype: dns.TypeA, soa: "mesh.internal."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the second answer comes from the negative cache
+			for i := 0; i < 2; i++ {
+				response := query(t, h, tt.qname, tt.qtype)
+				if response.Rcode != tt.rcode {
+					t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+				}
+				if got := negativeZone(response); got != tt.soa {
+					t.Fatalf("SOA of %q, want %q", got, tt.soa)
+				}
+				if tt.soa != "" && response.Ns[0].Header().Ttl != soaMinimum {
+					t.Errorf("SOA TTL = %d, want %d", response.Ns[0].Header().Ttl, soaMinimum)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// endpointPorts indexes the IP endpoints of a ServiceEntry by the ports they
+// listen on: the port an endpoint maps a service port name to, or the service
+// port number itself if the endpoint does not map it.
+func endpointPorts(entry *networking.ServiceEntry) map[uint32][]net.IP {
+	if len(entry.Endpoints) == 0 {
+		return nil
+	}
+	ports := make(map[uint32][]net.IP)
+	for _, endpoint := range entry.Endpoints {
+		ip := net.ParseIP(endpoint.Address)
+		if ip == nil {
+			continue
+		}
+		listening := make(map[uint32]bool)
+		for _, port := range entry.Ports {
+			number := port.Number
+			if mapped, found := endpoint.Ports[port.Name]; found {
+				number = mapped
+			}
+			if !listening[number] {
+				listening[number] = true
+				ports[number] = append(ports[number], ip)
+			}
+		}
+	}
+	return ports
+}
+
+// portEntries builds the entries of the _<port>.<host> names of entry, one
+// for each port it has endpoints on, once per table rather than per query so
+// that their capped answers rotate. Their tenants hold the tenant endpoints
+// listening on the port.
+func portEntries(entry *dnsEntry) map[uint32]*dnsEntry {
+	if len(entry.ports) == 0 {
+		return nil
+	}
+	entries := make(map[uint32]*dnsEntry, len(entry.ports))
+	for port, ips := range entry.ports {
+		entries[port] = &dnsEntry{vips: ips, ttl: entry.ttl, maxAnswers: entry.maxAnswers, stable: entry.stable, ordered: entry.ordered, tenants: tenantsWithin(entry.tenants, ips), exportTo: entry.exportTo}
+	}
+	return entries
+}
+
+// samePorts reports whether two port indexes hold the same addresses.
+func samePorts(a, b map[uint32][]net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for port, ips := range a {
+		if !sameIPs(ips, b[port]) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPortLabel splits a name of the form _<port>.<host> into the port and
+// the host.
+func splitPortLabel(name string) (uint32, string, bool) {
+	if !strings.HasPrefix(name, "_") {
+		return 0, "", false
+	}
+	parts := strings.SplitN(name[1:], ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", false
+	}
+	port, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, "", false
+	}
+	return uint32(port), parts[1], true
+}
+
+// lookupAddresses returns the table entry for name like lookup does. With
+// --port-labels, a name of the form _<port>.<host> resolves to the endpoints
+// of host listening on port, without records if there are none.
+func (h *IstioServiceEntries) lookupAddresses(v tableView, name string) *dnsEntry {
+	if h.portLabels {
+		if port, host, ok := splitPortLabel(name); ok {
+			entry := v.lookup(host)
+			if entry == nil {
+				return nil
+			}
+			if portEntry, found := entry.portEntries[port]; found {
+				return portEntry
+			}
+			// the host has no endpoints on port
+			return &dnsEntry{ttl: entry.ttl, changedAt: entry.changedAt}
+		}
+	}
+	return v.lookup(name)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestEndpointPorts(t *testing.T) {
+	entry := &networking.ServiceEntry{
+		Ports: []*networking.Port{
+			{Number: 80, Name: "http", Protocol: "HTTP"},
+			{Number: 443, Name: "https", Protocol: "HTTPS"},
+		},
+		Endpoints: []*networking.ServiceEntry_Endpoint{
+			{Address: "10.0.0.1"},
+			{Address: "10.0.0.2", Ports: map[string]uint32{"http": 8080}},
+			{Address: "10.0.0.3", Ports: map[string]uint32{"http": 443}},
+			{Address: "db.example.com"},
+		},
+	}
+	want := map[uint32][]string{
+		80:   {"10.0.0.1"},
+		443:  {"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		8080: {"10.0.0.2"},
+	}
+	got := make(map[uint32][]string)
+	for port, ips := range endpointPorts(entry) {
+		got[port] = ipStrings(ips)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("endpointPorts() = %v, want %v", got, want)
+	}
+}
+
+func TestSplitPortLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		port uint32
+		host string
+		ok   bool
+	}{
+		{name: "_8080.foo.com.", port: 8080, host: "foo.com.", ok: true},
+		{name: "foo.com."},
+		{name: "_http.foo.com."},
+		{name: "_70000.foo.com."},
+		{name: "_80."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, host, ok := splitPortLabel(tt.name)
+			if port != tt.port || host != tt.host || ok != tt.ok {
+				t.Errorf("splitPortLabel() = %d, %q, %v, want %d, %q, %v", port, host, ok, tt.port, tt.host, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPortLabels(t *testing.T) {
+	entry := &dnsEntry{
+		vips:       ips("10.1.0.1"),
+		maxAnswers: 1,
+		ports: map[uint32][]net.IP{
+			80:   ips("10.0.0.1", "10.0.0.2"),
+			8080: ips("10.0.0.3"),
+		},
+	}
+	entry.portEntries = portEntries(entry)
+	h := newTestHandle(map[string]*dnsEntry{"foo.com.": entry}, nil)
+	h.portLabels = true
+	tests := []struct {
+		name  string
+		rcode int
+		// want holds the answers of successive queries
+		want [][]string
+	}{
+		{name: "foo.com.", want: [][]string{{"10.1.0.1"}}},
+		// capped answers of a port name rotate
+		{name: "_80.foo.com.", want: [][]string{{"10.0.0.1"}, {"10.0.0.2"}, {"10.0.0.1"}}},
+		{name: "_8080.foo.com.", want: [][]string{{"10.0.0.3"}}},
+		// no endpoint listens on the port
+		{name: "_443.foo.com.", want: [][]string{nil}},
+		{name: "_80.bar.com.", rcode: dns.RcodeNameError, want: [][]string{nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				response := query(t, h, tt.name, dns.TypeA)
+				if response.Rcode != tt.rcode {
+					t.Fatalf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+				}
+				if got := rdata(response.Answer); !reflect.DeepEqual(got, want) {
+					t.Errorf("query %d: answer = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// chaosTXT answers CHAOS class TXT queries for version.bind and
+// hostname.bind with the configured strings; it reports false for other
+// queries and for names without a configured string.
+func (h *IstioServiceEntries) chaosTXT(q dns.Question) ([]dns.RR, bool) {
+	if q.Qclass != dns.ClassCHAOS || q.Qtype != dns.TypeTXT {
+		return nil, false
+	}
+	var value string
+	switch strings.ToLower(q.Name) {
+	case "version.bind.", "version.server.":
+		value = h.versionBind
+	case "hostname.bind.", "id.server.":
+		value = h.hostnameBind
+	}
+	if value == "" {
+		return nil, false
+	}
+	return []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0},
+		Txt: []string{value},
+	}}, true
+}
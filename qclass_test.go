@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryClass(t *testing.T) {
+	tests := []struct {
+		name       string
+		qname      string
+		qtype      uint16
+		qclass     uint16
+		classRcode int
+		rcode      int
+		want       []string
+	}{
+		{name: "internet", qname: "foo.com.", qtype: dns.TypeA, qclass: dns.ClassINET, want: []string{"10.0.0.1"}},
+		{name: "chaos refused", qname: "foo.com.", qtype: dns.TypeA, qclass: dns.ClassCHAOS, classRcode: dns.RcodeRefused, rcode: dns.RcodeRefused},
+		{name: "hesiod not implemented", qname: "foo.com.", qtype: dns.TypeA, qclass: dns.ClassHESIOD, classRcode: dns.RcodeNotImplemented, rcode: dns.RcodeNotImplemented},
+		{name: "version", qname: "version.bind.", qtype: dns.TypeTXT, qclass: dns.ClassCHAOS, classRcode: dns.RcodeRefused, want: []string{"1.0"}},
+		{name: "version server", qname: "VERSION.server.", qtype: dns.TypeTXT, qclass: dns.ClassCHAOS, classRcode: dns.RcodeRefused, want: []string{"1.0"}},
+		{name: "hostname", qname: "hostname.bind.", qtype: dns.TypeTXT, qclass: dns.ClassCHAOS, classRcode: dns.RcodeRefused, want: []string{"dns-0"}},
+		{name: "not TXT", qname: "id.server.", qtype: dns.TypeA, qclass: dns.ClassCHAOS, classRcode: dns.RcodeRefused, rcode: dns.RcodeRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.classRcode = tt.classRcode
+			h.versionBind = "1.0"
+			h.hostnameBind = "dns-0"
+			request := new(dns.Msg).SetQuestion(tt.qname, tt.qtype)
+			request.Question[0].Qclass = tt.qclass
+			response := exchange(t, h, request)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
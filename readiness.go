@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// markSynced records that a read of synced service entries completed. The
+// server becomes ready once the warm-up period after the first such read
+// has passed.
+func (h *IstioServiceEntries) markSynced() {
+	if atomic.LoadInt64(&h.readyAt) != 0 {
+		return
+	}
+	readyAt := time.Now().Add(h.warmup)
+	log.Printf("Service entries synced, ready at %v\n", readyAt)
+	atomic.StoreInt64(&h.readyAt, readyAt.UnixNano())
+}
+
+// ready reports whether the service entries were synced at least the warm-up
+// period ago.
+func (h *IstioServiceEntries) ready() bool {
+	readyAt := atomic.LoadInt64(&h.readyAt)
+	return readyAt != 0 && time.Now().UnixNano() >= readyAt
+}
+
+// gated reports whether queries are failed: before the first synced read
+// and during the warm-up period after it, unless serving before the sync, so
+// that a server that answered before the sync keeps answering through the
+// warm-up. An imported table does not open the gate, as it may be stale.
+func (h *IstioServiceEntries) gated() bool {
+	if h.ready() {
+		return false
+	}
+	return !h.serveBeforeSync
+}
+
+// readyz reports readiness; it fails until the service entries are synced and
+// the warm-up period has passed.
+func (h *IstioServiceEntries) readyz(w http.ResponseWriter, r *http.Request) {
+	if !h.ready() {
+		http.Error(w, "service entries not synced or warming up", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name   string
+		synced bool
+		warmup time.Duration
+		rcode  int
+		status int
+	}{
+		{name: "not synced", rcode: dns.RcodeServerFailure, status: http.StatusServiceUnavailable},
+		{name: "synced", synced: true, rcode: dns.RcodeSuccess, status: http.StatusOK},
+		{name: "warming up", synced: true, warmup: time.Hour, rcode: dns.RcodeServerFailure, status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.serveBeforeSync = false
+			h.warmup = tt.warmup
+			if tt.synced {
+				h.markSynced()
+			}
+			if response := query(t, h, "foo.com.", dns.TypeA); response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			w := httptest.NewRecorder()
+			h.adminHandler(false, false).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.status {
+				t.Errorf("/readyz status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestServeBeforeSync(t *testing.T) {
+	tests := []struct {
+		name            string
+		serveBeforeSync bool
+		synced          bool
+		rcode           int
+		want            []string
+	}{
+		{name: "failed before sync", rcode: dns.RcodeServerFailure},
+		{name: "served before sync", serveBeforeSync: true, want: []string{"10.0.0.1"}},
+		{name: "synced", synced: true, want: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newStoreHandle(t, serviceEntry("foo", nil, &networking.ServiceEntry{
+				Hosts: []string{"foo.com"}, Addresses: []string{"10.0.0.1"},
+				Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+				Resolution: networking.ServiceEntry_DNS,
+			}))
+			h.serveBeforeSync = tt.serveBeforeSync
+			h.synced = func() bool { return tt.synced }
+			h.readServiceEntries("")
+			response := query(t, h, "foo.com.", dns.TypeA)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	tests := []struct {
+		name            string
+		serveBeforeSync bool
+		fallback        bool
+		// rcodes before the sync, during the warm-up and after it
+		rcodes [3]int
+	}{
+		{name: "gated", rcodes: [3]int{dns.RcodeServerFailure, dns.RcodeServerFailure, dns.RcodeSuccess}},
+		{name: "served before sync", serveBeforeSync: true, rcodes: [3]int{dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeSuccess}},
+		{name: "fallback table", fallback: true, rcodes: [3]int{dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeSuccess}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newStoreHandle(t, serviceEntry("foo", nil, &networking.ServiceEntry{
+				Hosts: []string{"foo.com"}, Addresses: []string{"10.0.0.1"},
+				Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+				Resolution: networking.ServiceEntry_DNS,
+			}))
+			h.serveBeforeSync = tt.serveBeforeSync
+			if tt.fallback {
+				h.dnsEntries = map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}
+				h.fallback = true
+				h.serveBeforeSync = true
+			}
+			h.warmup = time.Hour
+			synced := false
+			h.synced = func() bool { return synced }
+			steps := []struct {
+				name   string
+				status int
+				step   func()
+			}{
+				{name: "before the sync", status: http.StatusServiceUnavailable, step: func() {}},
+				{name: "during the warm-up", status: http.StatusServiceUnavailable, step: func() {
+					synced = true
+					h.readServiceEntries("")
+				}},
+				{name: "after the warm-up", status: http.StatusOK, step: func() {
+					atomic.StoreInt64(&h.readyAt, time.Now().Add(-time.Second).UnixNano())
+				}},
+			}
+			h.readServiceEntries("")
+			for i, step := range steps {
+				step.step()
+				response := query(t, h, "foo.com.", dns.TypeA)
+				if response.Rcode != tt.rcodes[i] {
+					t.Errorf("%s: rcode = %s, want %s", step.name, dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcodes[i]])
+				}
+				w := httptest.NewRecorder()
+				h.adminHandler(false, false).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+				if w.Code != step.status {
+					t.Errorf("%s: /readyz status = %d, want %d", step.name, w.Code, step.status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// logName returns name as it may appear in logs and metric labels: as is, or
+// with --redact-names a token derived from a hash of the name. The same name
+// always maps to the same token, whatever its case.
+func (h *IstioServiceEntries) logName(name string) string {
+	if !h.redactNames {
+		return name
+	}
+	sum := sha256.Sum256([]byte(strings.ToLower(name)))
+	return "name-" + hex.EncodeToString(sum[:6])
+}
+
+// logQuery logs an incoming query, without the names in it if they are
+// redacted.
+func (h *IstioServiceEntries) logQuery(request *dns.Msg) {
+	if !h.redactNames {
+		log.Println("DNS query ", request)
+		return
+	}
+	for _, q := range request.Question {
+		log.Printf("DNS query %d: %s %s\n", request.Id, h.logName(q.Name), dns.TypeToString[q.Qtype])
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLogName(t *testing.T) {
+	redacting := &IstioServiceEntries{redactNames: true}
+	tests := []struct {
+		name   string
+		h      *IstioServiceEntries
+		a, b   string
+		same   bool
+		redact bool
+	}{
+		{name: "not redacted", h: &IstioServiceEntries{}, a: "foo.com.", b: "foo.com.", same: true},
+		{name: "same name", h: redacting, a: "foo.com.", b: "foo.com.", same: true, redact: true},
+		{name: "case", h: redacting, a: "foo.com.", b: "FoO.CoM.", same: true, redact: true},
+		{name: "other name", h: redacting, a: "foo.com.", b: "bar.com.", redact: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.h.logName(tt.a), tt.h.logName(tt.b)
+			if (a == b) != tt.same {
+				t.Errorf("logName(%s) = %s, logName(%s) = %s, want same %v", tt.a, a, tt.b, b, tt.same)
+			}
+			if plain := strings.Contains(a, "foo"); plain == tt.redact {
+				t.Errorf("logName(%s) = %s, want redacted %v", tt.a, a, tt.redact)
+			}
+		})
+	}
+}
+
+func TestLogQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		redactNames bool
+	}{
+		{name: "plain"},
+		{name: "redacted", redactNames: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			log.SetOutput(&b)
+			defer log.SetOutput(os.Stderr)
+			h := newTestHandle(map[string]*dnsEntry{"secret.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.redactNames = tt.redactNames
+			query(t, h, "secret.com.", dns.TypeA)
+			query(t, h, "missing.secret.com.", dns.TypeA)
+			if logged := strings.Contains(b.String(), "secret"); logged == tt.redactNames {
+				t.Errorf("log contains the name: %v, want %v:\n%s", logged, !tt.redactNames, b.String())
+			}
+		})
+	}
+}
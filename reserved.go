@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// reservedNames maps names, in lower case FQDN form, that are never answered
+// from the table to the rcode they get.
+type reservedNames map[string]int
+
+// parseReservedNames parses a comma separated list of name[=nxdomain|refused]
+// entries; the rcode defaults to NXDOMAIN.
+func parseReservedNames(list string) (reservedNames, error) {
+	var reserved reservedNames
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		rcode := dns.RcodeNameError
+		if len(parts) == 2 {
+			switch parts[1] {
+			case "nxdomain":
+			case "refused":
+				rcode = dns.RcodeRefused
+			default:
+				return nil, fmt.Errorf("unknown answer %q for %s, expected nxdomain or refused", parts[1], parts[0])
+			}
+		}
+		if reserved == nil {
+			reserved = make(reservedNames)
+		}
+		reserved[strings.ToLower(dns.Fqdn(parts[0]))] = rcode
+	}
+	return reserved, nil
+}
+
+// match returns the rcode for name if it is a reserved name or under one.
+func (r reservedNames) match(name string) (int, bool) {
+	name = strings.ToLower(name)
+	for reserved, rcode := range r {
+		if dns.IsSubDomain(reserved, name) {
+			return rcode, true
+		}
+	}
+	return 0, false
+}
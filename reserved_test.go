@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseReservedNames(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    reservedNames
+		wantErr bool
+	}{
+		{list: ""},
+		{list: "Metadata.Internal", want: reservedNames{"metadata.internal.": dns.RcodeNameError}},
+		{
+			list: "a.com=nxdomain, b.com.=refused,",
+			want: reservedNames{"a.com.": dns.RcodeNameError, "b.com.": dns.RcodeRefused},
+		},
+		{list: "a.com=servfail", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got, err := parseReservedNames(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReservedNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseReservedNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	reserved, err := parseReservedNames("foo.com,metadata.internal=refused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		qname string
+		rcode int
+		want  []string
+	}{
+		{name: "reserved", qname: "foo.com.", rcode: dns.RcodeNameError},
+		{name: "case", qname: "FOO.com.", rcode: dns.RcodeNameError},
+		{name: "subdomain", qname: "a.foo.com.", rcode: dns.RcodeNameError},
+		{name: "refused", qname: "metadata.internal.", rcode: dns.RcodeRefused},
+		{name: "suffix only", qname: "barfoo.com.", want: []string{"10.0.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{
+				"foo.com.":    {vips: ips("10.0.0.1")},
+				"a.foo.com.":  {vips: ips("10.0.0.1")},
+				"barfoo.com.": {vips: ips("10.0.0.2")},
+			}, nil)
+			h.reserved = reserved
+			response := query(t, h, tt.qname, dns.TypeA)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// malformedMode controls how query names with a URL scheme or port are
+// answered.
+type malformedMode int
+
+const (
+	// malformedOff looks such names up as they are
+	malformedOff malformedMode = iota
+	// malformedStrip looks them up without the scheme and port
+	malformedStrip
+	// malformedFormerr answers them with FORMERR
+	malformedFormerr
+)
+
+func parseMalformedMode(mode string) (malformedMode, error) {
+	switch mode {
+	case "off":
+		return malformedOff, nil
+	case "strip":
+		return malformedStrip, nil
+	case "formerr":
+		return malformedFormerr, nil
+	}
+	return malformedOff, fmt.Errorf("unknown mode %q, expected off, strip or formerr", mode)
+}
+
+// stripSchemePort removes a URL scheme prefix, such as http://, and a port
+// suffix, such as :8080, from a query name in FQDN form. It reports whether
+// the name had either.
+func stripSchemePort(name string) (string, bool) {
+	stripped := name
+	if i := strings.Index(stripped, "://"); i > 0 && isScheme(stripped[:i]) {
+		stripped = stripped[i+3:]
+	}
+	host := strings.TrimSuffix(stripped, ".")
+	if i := strings.LastIndex(host, ":"); i > 0 && i < len(host)-1 && isDigits(host[i+1:]) {
+		stripped = dns.Fqdn(host[:i])
+	}
+	if stripped == name || stripped == "." || stripped == "" {
+		return name, false
+	}
+	return stripped, true
+}
+
+// isScheme reports whether s is a URL scheme (RFC 3986).
+func isScheme(s string) bool {
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreOwner renames the answer records owned by name, the stripped query
+// name, to asked, the name of the question. The records are copied, as they
+// may be shared with the answer cache.
+func restoreOwner(answer []dns.RR, name string, asked string) {
+	for i, rr := range answer {
+		if strings.EqualFold(rr.Header().Name, name) {
+			answer[i] = dns.Copy(rr)
+			answer[i].Header().Name = asked
+		}
+	}
+}
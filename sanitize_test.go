@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseMalformedMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    malformedMode
+		wantErr bool
+	}{
+		{mode: "off", want: malformedOff},
+		{mode: "strip", want: malformedStrip},
+		{mode: "formerr", want: malformedFormerr},
+		{mode: "", wantErr: true},
+		{mode: "drop", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := parseMalformedMode(tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMalformedMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseMalformedMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripSchemePort(t *testing.T) {
+	tests := []struct {
+		name  string
+		want  string
+		found bool
+	}{
+		{name: "foo.com.", want: "foo.com."},
+		{name: "http://foo.com.", want: "foo.com.", found: true},
+		{name: "grpc+tls://foo.com.", want: "foo.com.", found: true},
+		{name: "foo.com:8080.", want: "foo.com.", found: true},
+		{name: "https://foo.com:443.", want: "foo.com.", found: true},
+		{name: "foo.com:http.", want: "foo.com:http."},
+		{name: "foo.com:.", want: "foo.com:."},
+		{name: "1http://foo.com.", want: "1http://foo.com."},
+		{name: "http://.", want: "http://."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := stripSchemePort(tt.name)
+			if got != tt.want || found != tt.found {
+				t.Errorf("stripSchemePort(%s) = %s, %v, want %s, %v", tt.name, got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestMalformedNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  malformedMode
+		qname string
+		rcode int
+		want  []string
+	}{
+		{name: "off", mode: malformedOff, qname: "http://foo.com.", rcode: dns.RcodeNameError},
+		{name: "strip scheme", mode: malformedStrip, qname: "http://foo.com.", want: []string{"10.0.0.1"}},
+		{name: "strip port", mode: malformedStrip, qname: "foo.com:8080.", want: []string{"10.0.0.1"}},
+		{name: "formerr", mode: malformedFormerr, qname: "foo.com:8080.", rcode: dns.RcodeFormatError},
+		{name: "well formed", mode: malformedFormerr, qname: "foo.com.", want: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.malformedNames = tt.mode
+			response := query(t, h, tt.qname, dns.TypeA)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+			// the answer is for the question as asked
+			if len(response.Question) != 1 || response.Question[0].Name != tt.qname {
+				t.Errorf("question = %v, want %s", response.Question, tt.qname)
+			}
+			for _, rr := range response.Answer {
+				if rr.Header().Name != tt.qname {
+					t.Errorf("owner = %s, want %s", rr.Header().Name, tt.qname)
+				}
+			}
+		})
+	}
+}
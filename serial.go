@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/miekg/dns"
+)
+
+// requestSerial returns the table serial a query carries in the EDNS0 local
+// option code, the serial of the answer the client last processed.
+func requestSerial(request *dns.Msg, code uint16) (uint32, bool) {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for _, o := range opt.Option {
+		if local, ok := o.(*dns.EDNS0_LOCAL); ok && local.Code == code && len(local.Data) == 4 {
+			return binary.BigEndian.Uint32(local.Data), true
+		}
+	}
+	return 0, false
+}
+
+// setSerial adds the serial of the table the response was answered from in
+// the EDNS0 local option code.
+func setSerial(request *dns.Msg, response *dns.Msg, code uint16, serial uint32) {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return
+	}
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, serial)
+
+	responseOpt := response.IsEdns0()
+	if responseOpt == nil {
+		response.SetEdns0(opt.UDPSize(), opt.Do())
+		responseOpt = response.IsEdns0()
+	}
+	responseOpt.Option = append(responseOpt.Option, &dns.EDNS0_LOCAL{Code: code, Data: data})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testSerialOption = 65002
+
+func TestConditionalQueries(t *testing.T) {
+	serial := func(n uint32) []byte {
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, n)
+		return data
+	}
+	tests := []struct {
+		name         string
+		serialOption uint16
+		// data is the serial option of the query, none if nil
+		data []byte
+		want []string
+		// wantSerial is the serial of the response, -1 if none
+		wantSerial int64
+	}{
+		{name: "disabled", data: serial(7), want: []string{"10.0.0.1"}, wantSerial: -1},
+		{name: "unconditional", serialOption: testSerialOption, want: []string{"10.0.0.1"}, wantSerial: -1},
+		{name: "unchanged", serialOption: testSerialOption, data: serial(7), wantSerial: 7},
+		{name: "changed", serialOption: testSerialOption, data: serial(6), want: []string{"10.0.0.1"}, wantSerial: 7},
+		{name: "malformed", serialOption: testSerialOption, data: []byte{7}, want: []string{"10.0.0.1"}, wantSerial: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.serial = 7
+			h.serialOption = tt.serialOption
+			request := new(dns.Msg).SetQuestion("foo.com.", dns.TypeA)
+			if tt.data != nil {
+				request.SetEdns0(1232, false)
+				opt := request.IsEdns0()
+				opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: testSerialOption, Data: tt.data})
+			}
+			response := exchange(t, h, request)
+			if response.Rcode != dns.RcodeSuccess {
+				t.Errorf("rcode = %s, want NOERROR", dns.RcodeToString[response.Rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+			got := int64(-1)
+			if n, found := requestSerial(response, testSerialOption); found {
+				got = int64(n)
+			}
+			if got != tt.wantSerial {
+				t.Errorf("serial = %d, want %d", got, tt.wantSerial)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	kubecfg "istio.io/istio/pkg/kube"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// serviceDomain is the cluster DNS domain Kubernetes Services are served under.
+const serviceDomain = "svc.cluster.local"
+
+// serviceSource maps Kubernetes Services into the DNS table. ClusterIP
+// services resolve to their cluster IP and ExternalName services to a CNAME
+// of the external name.
+type serviceSource struct {
+	lister corelisters.ServiceLister
+}
+
+// newServiceSource starts watching Services in the given namespace, or in all
+// namespaces if namespace is empty, and waits for the initial list.
+func newServiceSource(kubeconfig string, context string, namespace string, stop <-chan struct{}) (*serviceSource, error) {
+	config, err := kubecfg.BuildClientConfig(kubeconfig, context)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return watchServices(client, namespace, stop)
+}
+
+// watchServices starts watching Services through client in the given
+// namespace, or in all namespaces if namespace is empty, and waits for the
+// initial list.
+func watchServices(client kubernetes.Interface, namespace string, stop <-chan struct{}) (*serviceSource, error) {
+	factory := informers.NewFilteredSharedInformerFactory(client, 60*time.Second, namespace, nil)
+	informer := factory.Core().V1().Services()
+	s := &serviceSource{lister: informer.Lister()}
+	go informer.Informer().Run(stop)
+	if !cache.WaitForCacheSync(stop, informer.Informer().HasSynced) {
+		return nil, fmt.Errorf("failed to sync Kubernetes Services")
+	}
+	return s, nil
+}
+
+// addEntries adds an entry for every Service to dnsEntries, or, with a
+// sidecar scope, for every Service whose name it lists as an egress host of
+// the Service's namespace.
+func (s *serviceSource) addEntries(dnsEntries map[string]*dnsEntry, sidecar *sidecarScope) {
+	services, err := s.lister.List(labels.Everything())
+	if err != nil {
+		log.Printf("Failed to list Kubernetes Services: %v\n", err)
+		return
+	}
+	log.Printf("Have %d Kubernetes services\n", len(services))
+	for _, svc := range services {
+		key := fmt.Sprintf("%s.%s.%s.", svc.Name, svc.Namespace, serviceDomain)
+		if sidecar != nil && !sidecar.allows(svc.Namespace, key) {
+			continue
+		}
+		switch {
+		case svc.Spec.Type == v1.ServiceTypeExternalName:
+			if svc.Spec.ExternalName == "" {
+				continue
+			}
+			dnsEntries[key] = &dnsEntry{cname: dns.Fqdn(strings.ToLower(svc.Spec.ExternalName))}
+		case svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != v1.ClusterIPNone:
+			if ip := net.ParseIP(svc.Spec.ClusterIP); ip != nil {
+				dnsEntries[key] = &dnsEntry{vips: []net.IP{ip}}
+			}
+		}
+	}
+}
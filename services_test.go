@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// serviceAPI serves the Services of a Kubernetes API server: lists of all
+// Services or of those of one namespace, and watches without events.
+type serviceAPI struct {
+	services []v1.Service
+	mu       sync.Mutex
+	// listed holds the namespace of every list, "" for all namespaces
+	listed []string
+	// done ends the watches
+	done chan struct{}
+}
+
+func (a *serviceAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	namespace := ""
+	switch path := r.URL.Path; {
+	case path == "/api/v1/services":
+	case strings.HasPrefix(path, "/api/v1/namespaces/") && strings.HasSuffix(path, "/services"):
+		namespace = strings.TrimSuffix(strings.TrimPrefix(path, "/api/v1/namespaces/"), "/services")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("watch") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-a.done:
+		}
+		return
+	}
+	a.mu.Lock()
+	a.listed = append(a.listed, namespace)
+	a.mu.Unlock()
+	list := v1.ServiceList{TypeMeta: metav1.TypeMeta{Kind: "ServiceList", APIVersion: "v1"}, ListMeta: metav1.ListMeta{ResourceVersion: "1"}}
+	for _, svc := range a.services {
+		if namespace == "" || svc.Namespace == namespace {
+			list.Items = append(list.Items, svc)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// watchTestServices returns a source watching the services in namespace
+// through a client of a local API server serving them, stopped with the
+// test.
+func watchTestServices(t *testing.T, namespace string, services ...*v1.Service) (*serviceSource, *serviceAPI) {
+	t.Helper()
+	api := &serviceAPI{done: make(chan struct{})}
+	for _, svc := range services {
+		api.services = append(api.services, *svc)
+	}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(api.done) })
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	s, err := watchServices(client, namespace, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, api
+}
+
+// service returns a Service of the given spec.
+func service(name string, namespace string, spec v1.ServiceSpec) *v1.Service {
+	return &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Spec: spec}
+}
+
+func TestServiceSourceAddEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		service v1.ServiceSpec
+		want    *dnsEntry
+	}{
+		{
+			name:    "cluster-ip",
+			service: v1.ServiceSpec{Type: v1.ServiceTypeClusterIP, ClusterIP: "10.96.0.10"},
+			want:    &dnsEntry{vips: ips("10.96.0.10")},
+		},
+		{
+			name:    "external",
+			service: v1.ServiceSpec{Type: v1.ServiceTypeExternalName, ExternalName: "DB.Example.com"},
+			want:    &dnsEntry{cname: "db.example.com."},
+		},
+		{
+			name:    "headless",
+			service: v1.ServiceSpec{Type: v1.ServiceTypeClusterIP, ClusterIP: v1.ClusterIPNone},
+		},
+		{
+			name:    "external-empty",
+			service: v1.ServiceSpec{Type: v1.ServiceTypeExternalName},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := watchTestServices(t, "", service(tt.name, "default", tt.service))
+			dnsEntries := make(map[string]*dnsEntry)
+			s.addEntries(dnsEntries, nil)
+
+			got := dnsEntries[tt.name+".default.svc.cluster.local."]
+			if tt.want == nil {
+				if len(dnsEntries) != 0 {
+					t.Errorf("entries = %v, want none", dnsEntries)
+				}
+				return
+			}
+			if got == nil || !got.equal(tt.want) {
+				t.Errorf("entry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceSourceScope(t *testing.T) {
+	clusterIP := v1.ServiceSpec{Type: v1.ServiceTypeClusterIP, ClusterIP: "10.96.0.10"}
+	services := []*v1.Service{
+		service("api", "prod", clusterIP),
+		service("web", "prod", clusterIP),
+		service("api", "dev", clusterIP),
+	}
+	tests := []struct {
+		name      string
+		namespace string
+		sidecar   *sidecarScope
+		want      []string
+	}{
+		{
+			name: "all namespaces",
+			want: []string{"api.dev.svc.cluster.local.", "api.prod.svc.cluster.local.", "web.prod.svc.cluster.local."},
+		},
+		{
+			name:      "one namespace",
+			namespace: "prod",
+			want:      []string{"api.prod.svc.cluster.local.", "web.prod.svc.cluster.local."},
+		},
+		{
+			name:      "empty namespace",
+			namespace: "staging",
+		},
+		{
+			name:    "sidecar",
+			sidecar: &sidecarScope{namespace: "prod", egress: []string{"./api.prod.svc.cluster.local", "*/api.dev.svc.cluster.local"}},
+			want:    []string{"api.dev.svc.cluster.local.", "api.prod.svc.cluster.local."},
+		},
+		{
+			name:    "sidecar wildcard",
+			sidecar: &sidecarScope{namespace: "mesh", egress: []string{"prod/*.prod.svc.cluster.local"}},
+			want:    []string{"api.prod.svc.cluster.local.", "web.prod.svc.cluster.local."},
+		},
+		{
+			name:    "sidecar of another namespace",
+			sidecar: &sidecarScope{namespace: "mesh", egress: []string{"./*"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, api := watchTestServices(t, tt.namespace, services...)
+			api.mu.Lock()
+			listed := api.listed
+			api.mu.Unlock()
+			// the namespace is filtered by the API server
+			if len(listed) == 0 || listed[0] != tt.namespace {
+				t.Errorf("listed namespaces %q, want %q", listed, tt.namespace)
+			}
+			dnsEntries := make(map[string]*dnsEntry)
+			s.addEntries(dnsEntries, tt.sidecar)
+			var got []string
+			for key := range dnsEntries {
+				got = append(got, key)
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("hosts = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("hosts = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+	kubecfg "istio.io/istio/pkg/kube"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// The vendored pilot config model predates the Sidecar resource, so it is
+// read through the dynamic client instead of the CRD config store.
+var sidecarSchema = model.ProtoSchema{
+	Type:        "sidecar",
+	Plural:      "sidecars",
+	Group:       "networking",
+	Version:     "v1alpha3",
+	MessageName: "istio.networking.v1alpha3.Sidecar",
+}
+
+// sidecarScope limits the served hosts to the egress hosts of one Sidecar.
+type sidecarScope struct {
+	client    dynamic.ResourceInterface
+	namespace string
+	name      string
+	// egress holds the egress hosts in namespace/dnsName form
+	egress []string
+}
+
+// newSidecarScope creates a scope for the Sidecar named by ref, in
+// namespace/name form, and reads its egress hosts.
+func newSidecarScope(kubeconfig string, context string, ref string) (*sidecarScope, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid sidecar reference %q, expected namespace/name", ref)
+	}
+
+	config, err := kubecfg.BuildClientConfig(kubeconfig, context)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := schema.GroupVersionResource{
+		Group:    crd.ResourceGroup(&sidecarSchema),
+		Version:  sidecarSchema.Version,
+		Resource: sidecarSchema.Plural,
+	}
+	s := &sidecarScope{
+		client:    client.Resource(resource).Namespace(parts[0]),
+		namespace: parts[0],
+		name:      parts[1],
+	}
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// refresh re-reads the egress hosts of the Sidecar. On error the previously
+// read hosts are kept.
+func (s *sidecarScope) refresh() error {
+	obj, err := s.client.Get(s.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read sidecar %s/%s: %v", s.namespace, s.name, err)
+	}
+	config, err := crd.ConvertObjectFromUnstructured(sidecarSchema, obj, "")
+	if err != nil {
+		return fmt.Errorf("failed to convert sidecar %s/%s: %v", s.namespace, s.name, err)
+	}
+
+	egress := make([]string, 0)
+	for _, listener := range config.Spec.(*networking.Sidecar).Egress {
+		egress = append(egress, listener.Hosts...)
+	}
+	s.egress = egress
+	return nil
+}
+
+// allows reports whether the host of a ServiceEntry in the given namespace is
+// listed in the Sidecar's egress hosts.
+func (s *sidecarScope) allows(namespace string, host string) bool {
+	for _, egress := range s.egress {
+		parts := strings.SplitN(egress, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		ns, dnsName := parts[0], parts[1]
+		if ns == "." {
+			// "." refers to the namespace of the Sidecar itself
+			ns = s.namespace
+		}
+		if ns != "*" && ns != namespace {
+			continue
+		}
+		if egressHostMatches(canonicalHost(dnsName), canonicalHost(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// egressHostMatches reports whether host, which may itself be a wildcard of
+// the form *.foo.com, is covered by the egress dnsName.
+func egressHostMatches(dnsName string, host string) bool {
+	switch {
+	case dnsName == "*":
+		return true
+	case strings.HasPrefix(dnsName, "*."):
+		return strings.HasSuffix(host, dnsName[1:])
+	default:
+		return dnsName == host
+	}
+}
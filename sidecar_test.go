@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSidecarAllows(t *testing.T) {
+	s := &sidecarScope{namespace: "mesh", egress: []string{
+		"./local.com",
+		"prod/*.prod.com",
+		"*/shared.com.",
+		"invalid.com",
+	}}
+	tests := []struct {
+		name      string
+		namespace string
+		host      string
+		want      bool
+	}{
+		{name: "own namespace", namespace: "mesh", host: "local.com", want: true},
+		{name: "own namespace elsewhere", namespace: "prod", host: "local.com"},
+		{name: "wildcard", namespace: "prod", host: "api.prod.com", want: true},
+		{name: "wildcard host", namespace: "prod", host: "*.eu.prod.com", want: true},
+		{name: "wildcard apex", namespace: "prod", host: "prod.com"},
+		{name: "wildcard other namespace", namespace: "mesh", host: "api.prod.com"},
+		{name: "any namespace", namespace: "dev", host: "Shared.com", want: true},
+		{name: "without namespace", namespace: "mesh", host: "invalid.com"},
+		{name: "unlisted", namespace: "mesh", host: "other.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.allows(tt.namespace, tt.host); got != tt.want {
+				t.Errorf("allows(%s, %s) = %v, want %v", tt.namespace, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEgressHostMatches(t *testing.T) {
+	tests := []struct {
+		dnsName string
+		host    string
+		want    bool
+	}{
+		{dnsName: "*", host: "foo.com", want: true},
+		{dnsName: "*", host: "*.foo.com", want: true},
+		{dnsName: "foo.com", host: "foo.com", want: true},
+		{dnsName: "foo.com", host: "bar.foo.com"},
+		{dnsName: "*.foo.com", host: "bar.foo.com", want: true},
+		{dnsName: "*.foo.com", host: "barfoo.com"},
+		{dnsName: "*.foo.com", host: "*.foo.com", want: true},
+		{dnsName: "bar.foo.com", host: "*.foo.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dnsName+" "+tt.host, func(t *testing.T) {
+			if got := egressHostMatches(tt.dnsName, tt.host); got != tt.want {
+				t.Errorf("egressHostMatches(%s, %s) = %v, want %v", tt.dnsName, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSidecarScopeInvalid(t *testing.T) {
+	for _, ref := range []string{"", "sidecar", "/sidecar", "mesh/", "a/b/c"} {
+		t.Run(ref, func(t *testing.T) {
+			if _, err := newSidecarScope("", "", ref); err == nil {
+				t.Errorf("newSidecarScope(%q) succeeded", ref)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// answerSpecialName answers special-use domain names (RFC 6761) without
+// consulting the table: localhost names resolve to the loopback addresses
+// and .invalid and .test names do not exist. handled reports whether q is
+// such a name; nodata whether the name exists but has no records of the
+// queried type.
+func answerSpecialName(q dns.Question) (answer []dns.RR, handled bool, nodata bool) {
+	name := strings.ToLower(q.Name)
+	switch {
+	case name == "localhost." || strings.HasSuffix(name, ".localhost."):
+		loopback := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+		if answer := addressRecords(q.Name, q.Qtype, loopback, defaultTTL); len(answer) > 0 {
+			return answer, true, false
+		}
+		return nil, true, true
+	case name == "invalid." || strings.HasSuffix(name, ".invalid."),
+		name == "test." || strings.HasSuffix(name, ".test."):
+		return nil, true, false
+	}
+	return nil, false, false
+}
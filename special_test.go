@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSpecialNames(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		"foo.localhost.": {vips: ips("10.0.0.1")},
+		"foo.test.":      {vips: ips("10.0.0.2")},
+		"foo.com.":       {vips: ips("10.0.0.3")},
+	}, nil)
+	h.specialNames = true
+	tests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+		want  []string
+	}{
+		{name: "localhost.", qtype: dns.TypeA, want: []string{"127.0.0.1"}},
+		{name: "Foo.LocalHost.", qtype: dns.TypeAAAA, want: []string{"::1"}},
+		{name: "localhost.", qtype: dns.TypeANY, want: []string{"127.0.0.1", "::1"}},
+		{name: "localhost.", qtype: dns.TypeMX},
+		{name: "invalid.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{name: "foo.invalid.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{name: "foo.test.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{name: "test.", qtype: dns.TypeAAAA, rcode: dns.RcodeNameError},
+		{name: "foo.com.", qtype: dns.TypeA, want: []string{"10.0.0.3"}},
+		{name: "foo.testing.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			response := query(t, h, tt.name, tt.qtype)
+			if response.Rcode != tt.rcode {
+				t.Fatalf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// staticRecords holds fixed records by owner name and type, served
+// regardless of the service entries.
+type staticRecords map[string]map[uint16][]dns.RR
+
+// loadStaticRecords reads the records of an RFC 1035 zone file. $ORIGIN
+// directives in the file set the zone of the records that follow.
+func loadStaticRecords(path string) (staticRecords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := make(staticRecords)
+	zp := dns.NewZoneParser(f, ".", path)
+	zp.SetDefaultTTL(defaultTTL)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		if records[name] == nil {
+			records[name] = make(map[uint16][]dns.RR)
+		}
+		rrtype := rr.Header().Rrtype
+		records[name][rrtype] = append(records[name][rrtype], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return records, nil
+}
+
+// answer returns the records of q, owned by q.Name as asked, and whether the
+// name has any static records at all.
+func (s staticRecords) answer(q dns.Question) ([]dns.RR, bool) {
+	types, found := s[strings.ToLower(q.Name)]
+	if !found {
+		return nil, false
+	}
+	var answer []dns.RR
+	for rrtype, rrs := range types {
+		if rrtype != q.Qtype && q.Qtype != dns.TypeANY {
+			continue
+		}
+		for _, rr := range rrs {
+			rr = dns.Copy(rr)
+			rr.Header().Name = q.Name
+			answer = append(answer, rr)
+		}
+	}
+	return answer, true
+}
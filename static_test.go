@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const staticZone = `$ORIGIN example.org.
+www 60 IN A 192.0.2.1
+www 60 IN TXT "hello"
+$ORIGIN foo.com.
+@ 60 IN TXT "static"
+@ 60 IN A 192.0.2.2
+`
+
+func TestLoadStaticRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]int
+		wantErr bool
+	}{
+		{name: "origins", content: staticZone, want: map[string]int{"www.example.org.": 2, "foo.com.": 2}},
+		{name: "empty", content: "", want: map[string]int{}},
+		{name: "invalid", content: "www.example.org. 60 IN A not-an-address\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := loadStaticRecords(writeTable(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadStaticRecords() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := make(map[string]int)
+			for name, types := range records {
+				for _, rrs := range types {
+					got[name] += len(rrs)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaticRecords(t *testing.T) {
+	records, err := loadStaticRecords(writeTable(t, staticZone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		qname           string
+		qtype           uint16
+		tablePrecedence bool
+		want            []string
+		rcode           int
+	}{
+		{name: "static only", qname: "www.example.org.", qtype: dns.TypeTXT, want: []string{"hello"}},
+		{name: "case preserved", qname: "WWW.example.org.", qtype: dns.TypeA, want: []string{"192.0.2.1"}},
+		{name: "nodata", qname: "www.example.org.", qtype: dns.TypeAAAA, rcode: dns.RcodeSuccess},
+		{name: "static first", qname: "foo.com.", qtype: dns.TypeA, want: []string{"192.0.2.2"}},
+		{name: "table first", qname: "foo.com.", qtype: dns.TypeA, tablePrecedence: true, want: []string{"10.0.0.1"}},
+		{name: "static fills in", qname: "foo.com.", qtype: dns.TypeTXT, tablePrecedence: true, want: []string{"static"}},
+		{name: "unknown", qname: "bar.example.org.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.static = records
+			h.tablePrecedence = tt.tablePrecedence
+			response := query(t, h, tt.qname, tt.qtype)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+			for _, rr := range response.Answer {
+				if rr.Header().Name != tt.qname {
+					t.Errorf("owner = %s, want %s", rr.Header().Name, tt.qname)
+				}
+			}
+		})
+	}
+}
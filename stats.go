@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultTopHosts is the number of hosts /debug/queries shows by default.
+const defaultTopHosts = 20
+
+// otherHosts is the host label of queries for hosts beyond the metric limit,
+// and the host queries beyond maxStatsHosts are counted under.
+const otherHosts = "other"
+
+// maxStatsHosts bounds the hosts counted on their own between resets.
+const maxStatsHosts = 10000
+
+var hostQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "istio_coredns_host_queries_total",
+	Help: "Number of answered queries per host and query type, for the first --query-stats-metric-hosts hosts.",
+}, []string{"host", "type"})
+
+func init() {
+	prometheus.MustRegister(hostQueries)
+}
+
+// queryStats counts the answered queries of each host by query type.
+type queryStats struct {
+	mu     sync.Mutex
+	counts map[string]map[string]uint64
+	// metricHosts bounds the hosts with their own metric label
+	metricHosts int
+	labelled    map[string]bool
+}
+
+func newQueryStats(metricHosts int) *queryStats {
+	return &queryStats{
+		counts:      make(map[string]map[string]uint64),
+		metricHosts: metricHosts,
+		labelled:    make(map[string]bool),
+	}
+}
+
+// statsHost returns the host the queries for name are counted under: the
+// wildcard host matching it, if it is not in the table itself, so that the
+// names of a wildcard do not each get counts of their own.
+func (v tableView) statsHost(name string) string {
+	name = strings.ToLower(dns.Fqdn(name))
+	if v.dnsEntries[name] != nil {
+		return name
+	}
+	if key := v.wildcardKey(name); key != "" {
+		return "*" + key
+	}
+	return name
+}
+
+// record counts an answered query for name.
+func (s *queryStats) record(name string, qtype uint16) {
+	if s == nil {
+		return
+	}
+	host := strings.ToLower(name)
+	typ := dns.TypeToString[qtype]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts[host] == nil {
+		if len(s.counts) >= maxStatsHosts {
+			host = otherHosts
+		}
+		if s.counts[host] == nil {
+			s.counts[host] = make(map[string]uint64)
+		}
+	}
+	s.counts[host][typ]++
+
+	if s.metricHosts <= 0 {
+		return
+	}
+	if !s.labelled[host] {
+		if len(s.labelled) >= s.metricHosts {
+			host = otherHosts
+		} else {
+			s.labelled[host] = true
+		}
+	}
+	hostQueries.WithLabelValues(host, typ).Inc()
+}
+
+// reset forgets the counts. Metric labels are kept, as counters must not
+// go back.
+func (s *queryStats) reset() {
+	s.mu.Lock()
+	s.counts = make(map[string]map[string]uint64)
+	s.mu.Unlock()
+}
+
+// resetEvery resets the counts at every interval until stop is closed.
+func (s *queryStats) resetEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.reset()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// hostCount is one host of /debug/queries.
+type hostCount struct {
+	Host    string            `json:"host"`
+	Total   uint64            `json:"total"`
+	ByQtype map[string]uint64 `json:"byQtype"`
+}
+
+// top returns the n hosts with the most queries, most queried first.
+func (s *queryStats) top(n int) []hostCount {
+	s.mu.Lock()
+	hosts := make([]hostCount, 0, len(s.counts))
+	for host, byQtype := range s.counts {
+		c := hostCount{Host: host, ByQtype: make(map[string]uint64, len(byQtype))}
+		for typ, count := range byQtype {
+			c.ByQtype[typ] = count
+			c.Total += count
+		}
+		hosts = append(hosts, c)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Total != hosts[j].Total {
+			return hosts[i].Total > hosts[j].Total
+		}
+		return hosts[i].Host < hosts[j].Host
+	})
+	if len(hosts) > n {
+		hosts = hosts[:n]
+	}
+	return hosts
+}
+
+// ServeHTTP shows the most queried hosts as JSON, as many as the top query
+// parameter asks for. A POST resets the counts first.
+func (s *queryStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := defaultTopHosts
+	if v := r.URL.Query().Get("top"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			http.Error(w, "invalid top "+strconv.Quote(v), http.StatusBadRequest)
+			return
+		}
+	}
+	if r.Method == http.MethodPost {
+		s.reset()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.top(n)); err != nil {
+		log.Printf("Failed to write query statistics: %v\n", err)
+	}
+}
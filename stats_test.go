@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestStatsHost(t *testing.T) {
+	v := tableView{dnsEntries: map[string]*dnsEntry{
+		"foo.com.":     {vips: ips("10.0.0.1")},
+		".wild.com.":   {vips: ips("10.0.0.2")},
+		"a.wild.com.":  {vips: ips("10.0.0.3")},
+		".b.wild.com.": {vips: ips("10.0.0.4")},
+		"exact.b.com.": {vips: ips("10.0.0.5")},
+		".unrelated.":  {vips: ips("10.0.0.6")},
+	}}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "foo.com.", want: "foo.com."},
+		{name: "FOO.com", want: "foo.com."},
+		{name: "x.wild.com.", want: "*.wild.com."},
+		{name: "y.x.wild.com.", want: "*.wild.com."},
+		{name: "a.wild.com.", want: "a.wild.com."},
+		{name: "x.b.wild.com.", want: "*.b.wild.com."},
+		{name: "bar.com.", want: "bar.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := v.statsHost(tt.name); got != tt.want {
+				t.Errorf("statsHost(%s) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []dns.Question
+		target  string
+		method  string
+		status  int
+		want    []hostCount
+	}{
+		{
+			name: "most queried first",
+			queries: []dns.Question{
+				{Name: "foo.com.", Qtype: dns.TypeA},
+				{Name: "x.wild.com.", Qtype: dns.TypeA},
+				{Name: "y.wild.com.", Qtype: dns.TypeAAAA},
+				{Name: "missing.com.", Qtype: dns.TypeA},
+			},
+			target: "/debug/queries",
+			want: []hostCount{
+				{Host: "*.wild.com.", Total: 2, ByQtype: map[string]uint64{"A": 1, "AAAA": 1}},
+				{Host: "foo.com.", Total: 1, ByQtype: map[string]uint64{"A": 1}},
+			},
+		},
+		{
+			name: "top",
+			queries: []dns.Question{
+				{Name: "foo.com.", Qtype: dns.TypeA},
+				{Name: "x.wild.com.", Qtype: dns.TypeA},
+				{Name: "y.wild.com.", Qtype: dns.TypeA},
+			},
+			target: "/debug/queries?top=1",
+			want:   []hostCount{{Host: "*.wild.com.", Total: 2, ByQtype: map[string]uint64{"A": 2}}},
+		},
+		{
+			name:    "reset",
+			queries: []dns.Question{{Name: "foo.com.", Qtype: dns.TypeA}},
+			target:  "/debug/queries",
+			method:  http.MethodPost,
+			want:    []hostCount{},
+		},
+		{name: "invalid top", target: "/debug/queries?top=0", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{
+				"foo.com.":   {vips: ips("10.0.0.1")},
+				".wild.com.": {vips: ips("10.0.0.2", "fd00::2")},
+			}, nil)
+			h.stats = newQueryStats(0)
+			for _, q := range tt.queries {
+				query(t, h, q.Name, q.Qtype)
+			}
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			h.adminHandler(false, false).ServeHTTP(w, httptest.NewRequest(method, tt.target, nil))
+			status := tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if w.Code != status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			var got []hostCount
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryStatsLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		hosts       int
+		metricHosts int
+		// wantCounted is the number of hosts counted on their own
+		wantCounted int
+		wantOther   uint64
+		wantMetric  float64
+	}{
+		{name: "below the cap", hosts: 10, wantCounted: 10},
+		{name: "beyond the cap", hosts: maxStatsHosts + 5, wantCounted: maxStatsHosts, wantOther: 5},
+		{name: "metric hosts", hosts: 10, metricHosts: 4, wantCounted: 10, wantMetric: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newQueryStats(tt.metricHosts)
+			before := metricValue(t, hostQueries.WithLabelValues(otherHosts, "SRV"))
+			for i := 0; i < tt.hosts; i++ {
+				s.record(fmt.Sprintf("host%d.com.", i), dns.TypeSRV)
+			}
+			counted := 0
+			var other uint64
+			for _, c := range s.top(tt.hosts) {
+				if c.Host == otherHosts {
+					other = c.Total
+					continue
+				}
+				counted++
+			}
+			if counted != tt.wantCounted || other != tt.wantOther {
+				t.Errorf("counted %d hosts and %d other, want %d and %d", counted, other, tt.wantCounted, tt.wantOther)
+			}
+			after := metricValue(t, hostQueries.WithLabelValues(otherHosts, "SRV"))
+			if got := after - before; got != tt.wantMetric {
+				t.Errorf("other metric increased by %v, want %v", got, tt.wantMetric)
+			}
+		})
+	}
+}
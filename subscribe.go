@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// subscriberBuffer bounds the number of undelivered diffs queued per subscriber.
+const subscriberBuffer = 16
+
+// SnapshotDiff describes how the DNS table changed between two consecutive
+// reads of the service entries. Host names are in the same form as the table
+// keys, i.e. fully qualified, with wildcard hosts prefixed by a dot.
+type SnapshotDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+func (d SnapshotDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+type diffKind int
+
+const (
+	hostAdded diffKind = iota
+	hostRemoved
+	hostChanged
+)
+
+// subscriber holds a consumer channel and the diff that could not be delivered
+// because the consumer fell behind.
+type subscriber struct {
+	ch      chan SnapshotDiff
+	pending map[string]diffKind
+}
+
+// Subscribe returns a channel on which a SnapshotDiff is delivered every time
+// the DNS table changes. The channel is buffered; if a consumer falls behind,
+// further diffs are coalesced and delivered with the next change.
+func (h *IstioServiceEntries) Subscribe() <-chan SnapshotDiff {
+	s := &subscriber{ch: make(chan SnapshotDiff, subscriberBuffer)}
+	h.subMutex.Lock()
+	h.subscribers = append(h.subscribers, s)
+	h.subMutex.Unlock()
+	return s.ch
+}
+
+// publish hands the diff to every subscriber without blocking.
+func (h *IstioServiceEntries) publish(d SnapshotDiff) {
+	if d.empty() {
+		return
+	}
+	h.subMutex.Lock()
+	defer h.subMutex.Unlock()
+	for _, s := range h.subscribers {
+		changes := toChanges(d)
+		if s.pending != nil {
+			changes = mergeChanges(s.pending, changes)
+		}
+		out := fromChanges(changes)
+		if out.empty() {
+			s.pending = nil
+			continue
+		}
+		select {
+		case s.ch <- out:
+			s.pending = nil
+		default:
+			s.pending = changes
+		}
+	}
+}
+
+// diffEntries compares two versions of the DNS table.
+func diffEntries(old, cur map[string]*dnsEntry) SnapshotDiff {
+	var d SnapshotDiff
+	for k, v := range cur {
+		prev, found := old[k]
+		if !found {
+			d.Added = append(d.Added, k)
+		} else if !prev.equal(v) {
+			d.Changed = append(d.Changed, k)
+		}
+	}
+	for k := range old {
+		if _, found := cur[k]; !found {
+			d.Removed = append(d.Removed, k)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+// markChanges records when the entries of cur last changed: now for the
+// changed hosts of diff, the time recorded in old for the others. Unchanged
+// entries also carry over the rotation of their capped answers, which would
+// otherwise restart from the first address on every read. The entries of
+// the port names of a host follow the host.
+func markChanges(old, cur map[string]*dnsEntry, diff SnapshotDiff, now time.Time) {
+	changed := make(map[string]bool, len(diff.Changed))
+	for _, k := range diff.Changed {
+		changed[k] = true
+	}
+	for k, v := range cur {
+		prev, found := old[k]
+		if found && prev == v {
+			// retained from the previous table, already marked
+			continue
+		}
+		if changed[k] {
+			v.changedAt = now
+		} else if found {
+			v.changedAt = prev.changedAt
+			atomic.StoreUint32(&v.rotation, atomic.LoadUint32(&prev.rotation))
+		}
+		for port, e := range v.portEntries {
+			e.changedAt = v.changedAt
+			if found && !changed[k] {
+				if p := prev.portEntries[port]; p != nil {
+					atomic.StoreUint32(&e.rotation, atomic.LoadUint32(&p.rotation))
+				}
+			}
+		}
+	}
+}
+
+func sameIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func toChanges(d SnapshotDiff) map[string]diffKind {
+	changes := make(map[string]diffKind)
+	for _, k := range d.Added {
+		changes[k] = hostAdded
+	}
+	for _, k := range d.Removed {
+		changes[k] = hostRemoved
+	}
+	for _, k := range d.Changed {
+		changes[k] = hostChanged
+	}
+	return changes
+}
+
+// mergeChanges folds next into prev so that the result describes the net
+// change across both diffs.
+func mergeChanges(prev, next map[string]diffKind) map[string]diffKind {
+	merged := make(map[string]diffKind, len(prev)+len(next))
+	for k, v := range prev {
+		merged[k] = v
+	}
+	for k, v := range next {
+		before, found := merged[k]
+		switch {
+		case !found:
+			merged[k] = v
+		case before == hostAdded && v == hostRemoved:
+			// added and removed again before anyone noticed
+			delete(merged, k)
+		case before == hostAdded:
+			// still new as far as the consumer is concerned
+		case before == hostRemoved && v == hostAdded:
+			merged[k] = hostChanged
+		default:
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func fromChanges(changes map[string]diffKind) SnapshotDiff {
+	var d SnapshotDiff
+	for k, v := range changes {
+		switch v {
+		case hostAdded:
+			d.Added = append(d.Added, k)
+		case hostRemoved:
+			d.Removed = append(d.Removed, k)
+		case hostChanged:
+			d.Changed = append(d.Changed, k)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffEntries(t *testing.T) {
+	a := &dnsEntry{vips: []net.IP{net.ParseIP("10.0.0.1")}}
+	b := &dnsEntry{vips: []net.IP{net.ParseIP("10.0.0.2")}}
+	tests := []struct {
+		name string
+		old  map[string]*dnsEntry
+		cur  map[string]*dnsEntry
+		want SnapshotDiff
+	}{
+		{
+			name: "unchanged",
+			old:  map[string]*dnsEntry{"foo.com.": a},
+			cur:  map[string]*dnsEntry{"foo.com.": {vips: []net.IP{net.ParseIP("10.0.0.1")}}},
+		},
+		{
+			name: "added",
+			old:  map[string]*dnsEntry{},
+			cur:  map[string]*dnsEntry{"foo.com.": a, "bar.com.": b},
+			want: SnapshotDiff{Added: []string{"bar.com.", "foo.com."}},
+		},
+		{
+			name: "removed",
+			old:  map[string]*dnsEntry{"foo.com.": a},
+			cur:  map[string]*dnsEntry{},
+			want: SnapshotDiff{Removed: []string{"foo.com."}},
+		},
+		{
+			name: "changed",
+			old:  map[string]*dnsEntry{"foo.com.": a, ".wild.com.": a},
+			cur:  map[string]*dnsEntry{"foo.com.": b, ".wild.com.": a},
+			want: SnapshotDiff{Changed: []string{"foo.com."}},
+		},
+		{
+			name: "cname changed",
+			old:  map[string]*dnsEntry{"foo.com.": {cname: "a.example.com."}},
+			cur:  map[string]*dnsEntry{"foo.com.": {cname: "b.example.com."}},
+			want: SnapshotDiff{Changed: []string{"foo.com."}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffEntries(tt.old, tt.cur); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffEntries() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tests := []struct {
+		name  string
+		diffs []SnapshotDiff
+		// drain reads the channel after every diff instead of at the end
+		drain bool
+		want  []SnapshotDiff
+	}{
+		{
+			name:  "delivered",
+			diffs: []SnapshotDiff{{Added: []string{"foo.com."}}, {Changed: []string{"foo.com."}}},
+			drain: true,
+			want:  []SnapshotDiff{{Added: []string{"foo.com."}}, {Changed: []string{"foo.com."}}},
+		},
+		{
+			name:  "empty diff not delivered",
+			diffs: []SnapshotDiff{{}},
+			drain: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &IstioServiceEntries{}
+			ch := h.Subscribe()
+			var got []SnapshotDiff
+			for _, d := range tt.diffs {
+				h.publish(d)
+				if tt.drain {
+					got = append(got, receive(ch)...)
+				}
+			}
+			got = append(got, receive(ch)...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeCoalesces(t *testing.T) {
+	h := &IstioServiceEntries{}
+	ch := h.Subscribe()
+	// fill the buffer so that further diffs are held back
+	for i := 0; i < subscriberBuffer; i++ {
+		h.publish(SnapshotDiff{Changed: []string{"filler.com."}})
+	}
+	h.publish(SnapshotDiff{Added: []string{"foo.com.", "bar.com."}})
+	h.publish(SnapshotDiff{Removed: []string{"foo.com."}, Changed: []string{"bar.com."}})
+	h.publish(SnapshotDiff{Removed: []string{"baz.com."}})
+	if got := len(receive(ch)); got != subscriberBuffer {
+		t.Fatalf("received %d diffs, want %d", got, subscriberBuffer)
+	}
+	// the next change delivers the net change of the held back diffs
+	h.publish(SnapshotDiff{Added: []string{"baz.com."}})
+	want := []SnapshotDiff{{Added: []string{"bar.com."}, Changed: []string{"baz.com."}}}
+	if got := receive(ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("received %+v, want %+v", got, want)
+	}
+}
+
+func TestMarkChanges(t *testing.T) {
+	then := time.Unix(1000, 0)
+	now := time.Unix(2000, 0)
+	old := map[string]*dnsEntry{
+		"same.com.":    {vips: []net.IP{net.ParseIP("10.0.0.1")}, changedAt: then},
+		"changed.com.": {vips: []net.IP{net.ParseIP("10.0.0.1")}, changedAt: then},
+	}
+	cur := map[string]*dnsEntry{
+		"same.com.":    {vips: []net.IP{net.ParseIP("10.0.0.1")}},
+		"changed.com.": {vips: []net.IP{net.ParseIP("10.0.0.2")}},
+		"added.com.":   {vips: []net.IP{net.ParseIP("10.0.0.3")}},
+	}
+	markChanges(old, cur, diffEntries(old, cur), now)
+	tests := []struct {
+		host      string
+		changedAt time.Time
+		rotation  uint32
+	}{
+		{host: "same.com.", changedAt: then},
+		{host: "changed.com.", changedAt: now},
+		{host: "added.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := cur[tt.host].changedAt; !got.Equal(tt.changedAt) {
+				t.Errorf("changedAt = %v, want %v", got, tt.changedAt)
+			}
+		})
+	}
+}
+
+// receive returns the diffs queued on ch.
+func receive(ch <-chan SnapshotDiff) []SnapshotDiff {
+	var diffs []SnapshotDiff
+	for {
+		select {
+		case d := <-ch:
+			diffs = append(diffs, d)
+		default:
+			return diffs
+		}
+	}
+}
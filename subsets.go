@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"net"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// readSubsets returns the DestinationRule subsets of each host, or nil if
+// subset resolution is disabled.
+func (h *IstioServiceEntries) readSubsets() map[string][]*networking.Subset {
+	if !h.subsets {
+		return nil
+	}
+	rules, err := h.configStore.List(model.DestinationRule.Type, model.NamespaceAll)
+	if err != nil {
+		log.Printf("Failed to list destination rules: %v\n", err)
+		return nil
+	}
+	subsets := make(map[string][]*networking.Subset)
+	for _, r := range rules {
+		rule := r.Spec.(*networking.DestinationRule)
+		host := canonicalHost(rule.Host)
+		subsets[host] = append(subsets[host], rule.Subsets...)
+	}
+	return subsets
+}
+
+// addSubsetEntries adds an entry named <subset>.<host> for every subset of
+// the host, resolving to the addresses of the ServiceEntry endpoints whose
+// labels match the subset.
+func addSubsetEntries(dnsEntries map[string]*dnsEntry, key string, entry *networking.ServiceEntry, subsets []*networking.Subset, ttl uint32) {
+	for _, subset := range subsets {
+		vips := make([]net.IP, 0)
+		for _, endpoint := range entry.Endpoints {
+			if !model.Labels(subset.Labels).SubsetOf(endpoint.Labels) {
+				continue
+			}
+			if ip := net.ParseIP(endpoint.Address); ip != nil {
+				vips = append(vips, ip)
+			}
+		}
+		dnsEntries[subset.Name+"."+key] = &dnsEntry{vips: vips, ttl: ttl, subsetOf: key}
+	}
+}
+
+// dropOrphanSubsets drops the subset entries of hosts that are no longer in
+// dnsEntries or no longer have subsets, such as evicted hosts, so that they
+// go with their host. It returns the number of dropped entries.
+func dropOrphanSubsets(dnsEntries map[string]*dnsEntry) int {
+	dropped := 0
+	for k, e := range dnsEntries {
+		if e.subsetOf == "" {
+			continue
+		}
+		if parent, found := dnsEntries[e.subsetOf]; !found || !parent.hasSubsets {
+			delete(dnsEntries, k)
+			dropped++
+		}
+	}
+	return dropped
+}
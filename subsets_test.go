@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestSubsetEntries(t *testing.T) {
+	entry := &networking.ServiceEntry{
+		Endpoints: []*networking.ServiceEntry_Endpoint{
+			{Address: "10.0.0.1", Labels: map[string]string{"version": "v1", "zone": "a"}},
+			{Address: "10.0.0.2", Labels: map[string]string{"version": "v2", "zone": "a"}},
+			{Address: "10.0.0.3", Labels: map[string]string{"version": "v2", "zone": "b"}},
+		},
+	}
+	subsets := []*networking.Subset{
+		{Name: "v1", Labels: map[string]string{"version": "v1"}},
+		{Name: "v2", Labels: map[string]string{"version": "v2"}},
+		{Name: "v3", Labels: map[string]string{"version": "v3"}},
+	}
+	dnsEntries := map[string]*dnsEntry{
+		"foo.com.":  {vips: ips("10.1.0.1"), hasSubsets: true},
+		"bar.com.":  {vips: ips("10.2.0.1"), hasSubsets: true},
+		".bar.com.": {vips: ips("10.3.0.1")},
+	}
+	addSubsetEntries(dnsEntries, "foo.com.", entry, subsets, 0)
+	addSubsetEntries(dnsEntries, "bar.com.", entry, subsets, 0)
+	h := newTestHandle(dnsEntries, nil)
+
+	tests := []struct {
+		name  string
+		rcode int
+		want  []string
+	}{
+		{name: "foo.com.", want: []string{"10.1.0.1"}},
+		{name: "v1.foo.com.", want: []string{"10.0.0.1"}},
+		{name: "V2.foo.com.", want: []string{"10.0.0.2", "10.0.0.3"}},
+		// known subset without matching endpoints
+		{name: "v3.foo.com."},
+		// unknown subset of a host with subsets
+		{name: "v4.foo.com."},
+		{name: "v1.baz.com.", rcode: dns.RcodeNameError},
+		// next to a wildcard, known subsets keep their endpoints and
+		// other names get the wildcard's answer
+		{name: "v1.bar.com.", want: []string{"10.0.0.1"}},
+		{name: "v3.bar.com."},
+		{name: "v4.bar.com.", want: []string{"10.3.0.1"}},
+		{name: "www.bar.com.", want: []string{"10.3.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := query(t, h, tt.name, dns.TypeA)
+			if response.Rcode != tt.rcode {
+				t.Fatalf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubsetEntriesRemoved(t *testing.T) {
+	previous := map[string]*dnsEntry{
+		"foo.com.":    {vips: ips("10.1.0.1"), hasSubsets: true},
+		"v1.foo.com.": {vips: ips("10.0.0.1"), subsetOf: "foo.com."},
+		"bar.com.":    {vips: ips("10.2.0.1")},
+	}
+	tests := []struct {
+		name string
+		// table is the new table, before removed hosts are retained
+		table map[string]*dnsEntry
+		// evict drops these hosts after the removed ones are retained
+		evict []string
+		want  []string
+	}{
+		{
+			name:  "served",
+			table: map[string]*dnsEntry{"foo.com.": {vips: ips("10.1.0.1"), hasSubsets: true}, "v1.foo.com.": {vips: ips("10.0.0.1"), subsetOf: "foo.com."}},
+			want:  []string{"bar.com.", "foo.com.", "v1.foo.com."},
+		},
+		{
+			name:  "removed",
+			table: map[string]*dnsEntry{},
+			want:  []string{"bar.com.", "foo.com.", "v1.foo.com."},
+		},
+		{
+			name:  "decommissioned",
+			table: map[string]*dnsEntry{"foo.com.": {decommissioned: true}},
+			want:  []string{"bar.com.", "foo.com."},
+		},
+		{
+			name:  "without subsets",
+			table: map[string]*dnsEntry{"foo.com.": {vips: ips("10.1.0.1")}},
+			want:  []string{"bar.com.", "foo.com."},
+		},
+		{
+			name:  "evicted",
+			table: map[string]*dnsEntry{"foo.com.": {vips: ips("10.1.0.1"), hasSubsets: true}, "v1.foo.com.": {vips: ips("10.0.0.1"), subsetOf: "foo.com."}},
+			evict: []string{"foo.com."},
+			want:  []string{"bar.com."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(previous, nil)
+			h.removalGrace = 5 * time.Minute
+			h.retainRemoved(tt.table, time.Now())
+			for _, k := range tt.evict {
+				delete(tt.table, k)
+			}
+			dropOrphanSubsets(tt.table)
+			var got []string
+			for k := range tt.table {
+				got = append(got, k)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hosts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// defaultTenantOption is the EDNS0 local option code carrying the tenant tag
+// of a query.
+const defaultTenantOption = 65001
+
+// endpointTenants indexes the IP endpoints of a ServiceEntry by the value of
+// their label named label.
+func endpointTenants(entry *networking.ServiceEntry, label string) map[string][]net.IP {
+	if label == "" || len(entry.Endpoints) == 0 {
+		return nil
+	}
+	tenants := make(map[string][]net.IP)
+	for _, endpoint := range entry.Endpoints {
+		tenant, found := endpoint.Labels[label]
+		if !found {
+			continue
+		}
+		if ip := net.ParseIP(endpoint.Address); ip != nil {
+			tenants[tenant] = append(tenants[tenant], ip)
+		}
+	}
+	return tenants
+}
+
+// tenantsWithin restricts a tenant index to the addresses in ips, dropping
+// the tenants left without any.
+func tenantsWithin(tenants map[string][]net.IP, ips []net.IP) map[string][]net.IP {
+	if len(tenants) == 0 {
+		return nil
+	}
+	within := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		within[ip.String()] = true
+	}
+	restricted := make(map[string][]net.IP)
+	for tenant, tenantIPs := range tenants {
+		for _, ip := range tenantIPs {
+			if within[ip.String()] {
+				restricted[tenant] = append(restricted[tenant], ip)
+			}
+		}
+	}
+	return restricted
+}
+
+// sameTenants reports whether two tenant indexes hold the same addresses.
+func sameTenants(a, b map[string][]net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for tenant, ips := range a {
+		if !sameIPs(ips, b[tenant]) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestTenant returns the tenant tag carried by the request in the EDNS0
+// local option code, if any.
+func requestTenant(request *dns.Msg, code uint16) (string, bool) {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return "", false
+	}
+	for _, o := range opt.Option {
+		if local, ok := o.(*dns.EDNS0_LOCAL); ok && local.Code == code {
+			return string(local.Data), true
+		}
+	}
+	return "", false
+}
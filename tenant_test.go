@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func TestEndpointTenants(t *testing.T) {
+	entry := &networking.ServiceEntry{Endpoints: []*networking.ServiceEntry_Endpoint{
+		{Address: "10.0.0.1", Labels: map[string]string{"tenant": "a"}},
+		{Address: "10.0.0.2", Labels: map[string]string{"tenant": "b"}},
+		{Address: "10.0.0.3", Labels: map[string]string{"tenant": "a"}},
+		{Address: "10.0.0.4"},
+		{Address: "not-an-ip", Labels: map[string]string{"tenant": "a"}},
+	}}
+	tests := []struct {
+		name  string
+		label string
+		want  map[string][]net.IP
+	}{
+		{name: "disabled"},
+		{name: "by label", label: "tenant", want: map[string][]net.IP{"a": ips("10.0.0.1", "10.0.0.3"), "b": ips("10.0.0.2")}},
+		{name: "unknown label", label: "team", want: map[string][]net.IP{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := endpointTenants(entry, tt.label); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("endpointTenants() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantsWithin(t *testing.T) {
+	tenants := map[string][]net.IP{"a": ips("10.0.0.1", "10.0.0.3"), "b": ips("10.0.0.2")}
+	tests := []struct {
+		name    string
+		tenants map[string][]net.IP
+		ips     []net.IP
+		want    map[string][]net.IP
+	}{
+		{name: "no tenants", ips: ips("10.0.0.1")},
+		{name: "all", tenants: tenants, ips: ips("10.0.0.1", "10.0.0.2", "10.0.0.3"), want: tenants},
+		{name: "some", tenants: tenants, ips: ips("10.0.0.3"), want: map[string][]net.IP{"a": ips("10.0.0.3")}},
+		{name: "none", tenants: tenants, ips: ips("10.0.0.9"), want: map[string][]net.IP{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tenantsWithin(tt.tenants, tt.ips); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tenantsWithin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantAnswers(t *testing.T) {
+	h := newStoreHandle(t, serviceEntry("foo", nil, &networking.ServiceEntry{
+		Hosts:     []string{"foo.com"},
+		Addresses: []string{"10.1.0.1"},
+		Ports: []*networking.Port{
+			{Number: 80, Name: "http", Protocol: "HTTP"},
+			{Number: 8080, Name: "admin", Protocol: "HTTP"},
+		},
+		Resolution: networking.ServiceEntry_STATIC,
+		Endpoints: []*networking.ServiceEntry_Endpoint{
+			{Address: "10.0.0.1", Labels: map[string]string{"tenant": "a"}},
+			{Address: "10.0.0.2", Labels: map[string]string{"tenant": "b"}, Ports: map[string]uint32{"admin": 9090}},
+			{Address: "10.0.0.3", Labels: map[string]string{"tenant": "a"}, Ports: map[string]uint32{"http": 8000}},
+		},
+	}), serviceEntry("bar", nil, &networking.ServiceEntry{
+		Hosts:      []string{"bar.com"},
+		Addresses:  []string{"10.2.0.1"},
+		Ports:      []*networking.Port{{Number: 80, Name: "http", Protocol: "HTTP"}},
+		Resolution: networking.ServiceEntry_DNS,
+	}))
+	h.tenantLabel = "tenant"
+	h.tenantOption = defaultTenantOption
+	h.portLabels = true
+	h.readServiceEntries("")
+	tests := []struct {
+		name   string
+		qname  string
+		tenant string
+		want   []string
+	}{
+		{name: "untagged", qname: "foo.com.", want: []string{"10.1.0.1"}},
+		{name: "tenant a", qname: "foo.com.", tenant: "a", want: []string{"10.0.0.1", "10.0.0.3"}},
+		{name: "tenant b", qname: "foo.com.", tenant: "b", want: []string{"10.0.0.2"}},
+		{name: "unknown tenant", qname: "foo.com.", tenant: "c", want: []string{"10.1.0.1"}},
+		{name: "port untagged", qname: "_80.foo.com.", want: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "port tenant a", qname: "_80.foo.com.", tenant: "a", want: []string{"10.0.0.1"}},
+		{name: "other port tenant a", qname: "_8000.foo.com.", tenant: "a", want: []string{"10.0.0.3"}},
+		{name: "other port tenant b", qname: "_8000.foo.com.", tenant: "b", want: []string{"10.0.0.3"}},
+		{name: "only VIPs untagged", qname: "bar.com.", want: []string{"10.2.0.1"}},
+		{name: "only VIPs tenant a", qname: "bar.com.", tenant: "a", want: []string{"10.2.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := new(dns.Msg).SetQuestion(tt.qname, dns.TypeA)
+			if tt.tenant != "" {
+				request.SetEdns0(1232, false)
+				opt := request.IsEdns0()
+				opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: defaultTenantOption, Data: []byte(tt.tenant)})
+			}
+			if got := rdata(exchange(t, h, request).Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultTTL is the TTL, in seconds, of every answer
+	defaultTTL = 3600
+	// jitterWindow is how long a jittered TTL stays stable for the same answer
+	jitterWindow = 60 * time.Second
+)
+
+// answerTTL returns the TTL for a positive answer to name carrying the given
+// record data, based on the TTL base: jittered, then raised to the TTL floor.
+func (h *IstioServiceEntries) answerTTL(name string, base uint32, rdata []string) uint32 {
+	return h.floorTTL(h.jitterTTL(name, base, rdata))
+}
+
+// entryAnswerTTL returns the TTL for a positive answer to name from the
+// entry, with records of type rrtype carrying the given record data. The
+// entry's own TTL is a ceiling, so that neither jitter nor --min-ttl raises
+// the TTL of draining hosts.
+func (h *IstioServiceEntries) entryAnswerTTL(e *dnsEntry, name string, rrtype uint16, rdata []string) uint32 {
+	ttl := h.answerTTL(name, h.entryTTL(e, rrtype), rdata)
+	if e.ttl > 0 && ttl > e.ttl {
+		return e.ttl
+	}
+	return ttl
+}
+
+// entryTTL returns the TTL base of records of type rrtype for the entry: the
+// --qtype-ttl of the type if set, lowered to the entry's own TTL if that is
+// shorter, so that draining hosts stay short lived. Within --change-window
+// of a change of the entry, it is lowered to --change-ttl.
+func (h *IstioServiceEntries) entryTTL(e *dnsEntry, rrtype uint16) uint32 {
+	ttl, found := h.typeTTLs[rrtype]
+	if !found {
+		ttl = e.baseTTL()
+	} else if e.ttl > 0 && e.ttl < ttl {
+		ttl = e.ttl
+	}
+	if h.changeTTL > 0 && h.changeTTL < ttl && !e.changedAt.IsZero() && time.Since(e.changedAt) < h.changeWindow {
+		ttl = h.changeTTL
+	}
+	return ttl
+}
+
+// typeTTL returns the TTL base of records of type rrtype not served from a
+// table entry.
+func (h *IstioServiceEntries) typeTTL(rrtype uint16) uint32 {
+	if ttl, found := h.typeTTLs[rrtype]; found {
+		return ttl
+	}
+	return defaultTTL
+}
+
+// parseTypeTTLs parses a comma separated list of TYPE=seconds pairs.
+func parseTypeTTLs(list string) (map[uint16]uint32, error) {
+	var ttls map[uint16]uint32
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %q, expected TYPE=seconds", pair)
+		}
+		rrtype, found := dns.StringToType[strings.ToUpper(strings.TrimSpace(parts[0]))]
+		if !found {
+			return nil, fmt.Errorf("unknown record type %q", parts[0])
+		}
+		ttl, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+		if err != nil || ttl == 0 {
+			return nil, fmt.Errorf("invalid TTL %q for %s", parts[1], parts[0])
+		}
+		if ttls == nil {
+			ttls = make(map[uint16]uint32)
+		}
+		ttls[rrtype] = uint32(ttl)
+	}
+	return ttls, nil
+}
+
+// floorTTL raises ttl to the configured minimum TTL of positive answers.
+func (h *IstioServiceEntries) floorTTL(ttl uint32) uint32 {
+	if ttl < h.minTTL {
+		return h.minTTL
+	}
+	return ttl
+}
+
+// jitterTTL spreads base over +/- ttlJitter percent of itself. The spread is
+// derived from a hash of the name, the record data and the current
+// jitterWindow, so that retries see the same TTL. All records of one RRset
+// share a TTL, as required by RFC 2181.
+func (h *IstioServiceEntries) jitterTTL(name string, base uint32, rdata []string) uint32 {
+	if h.ttlJitter <= 0 {
+		return base
+	}
+
+	f := fnv.New64a()
+	// the case of the name varies with 0x20 randomization
+	f.Write([]byte(strings.ToLower(name)))
+	for _, r := range rdata {
+		f.Write([]byte{0})
+		f.Write([]byte(r))
+	}
+	f.Write([]byte(strconv.FormatInt(time.Now().UnixNano()/int64(jitterWindow), 10)))
+
+	spread := uint64(base) * uint64(h.ttlJitter) / 100
+	if spread == 0 {
+		return base
+	}
+	// offset is uniform in [0, 2*spread], i.e. base-spread..base+spread
+	offset := f.Sum64() % (2*spread + 1)
+	return uint32(uint64(base) - spread + offset)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestJitterTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		jitter   int
+		base     uint32
+		min, max uint32
+	}{
+		{name: "disabled", jitter: 0, base: 3600, min: 3600, max: 3600},
+		{name: "ten percent", jitter: 10, base: 3600, min: 3240, max: 3960},
+		{name: "full", jitter: 100, base: 30, min: 0, max: 60},
+		{name: "too short to spread", jitter: 10, base: 5, min: 5, max: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &IstioServiceEntries{ttlJitter: tt.jitter}
+			got := h.jitterTTL("foo.com.", tt.base, []string{"10.0.0.1"})
+			if got < tt.min || got > tt.max {
+				t.Errorf("jitterTTL() = %d, want between %d and %d", got, tt.min, tt.max)
+			}
+			// retries, also with another case of the name, see the same TTL
+			if again := h.jitterTTL("FOO.com.", tt.base, []string{"10.0.0.1"}); again != got {
+				t.Errorf("jitterTTL() = %d, then %d", got, again)
+			}
+		})
+	}
+}
+
+func TestFloorTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		minTTL   uint32
+		ttl      uint32
+		entryTTL uint32
+		want     uint32
+	}{
+		{name: "no floor", ttl: 5, want: 5},
+		{name: "raised", minTTL: 30, ttl: 5, want: 30},
+		{name: "above floor", minTTL: 30, ttl: 60, want: 60},
+		// the TTL of draining hosts is not raised
+		{name: "draining", minTTL: 30, ttl: 60, entryTTL: 5, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1"), ttl: tt.entryTTL}}, nil)
+			h.typeTTLs = map[uint16]uint32{dns.TypeA: tt.ttl}
+			h.minTTL = tt.minTTL
+			response := query(t, h, "foo.com.", dns.TypeA)
+			if len(response.Answer) != 1 || response.Answer[0].Header().Ttl != tt.want {
+				t.Errorf("answer = %v, want a TTL of %d", response.Answer, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTypeTTLs(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    map[uint16]uint32
+		wantErr bool
+	}{
+		{list: ""},
+		{list: "A=30", want: map[uint16]uint32{dns.TypeA: 30}},
+		{list: " a = 30 , PTR=600,", want: map[uint16]uint32{dns.TypeA: 30, dns.TypePTR: 600}},
+		{list: "A", wantErr: true},
+		{list: "BOGUS=30", wantErr: true},
+		{list: "A=0", wantErr: true},
+		{list: "A=-1", wantErr: true},
+		{list: "A=soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got, err := parseTypeTTLs(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTypeTTLs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTypeTTLs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeTTLs(t *testing.T) {
+	tests := []struct {
+		name     string
+		entryTTL uint32
+		typeTTLs map[uint16]uint32
+		qname    string
+		qtype    uint16
+		want     uint32
+	}{
+		{name: "default", qname: "foo.com.", qtype: dns.TypeA, want: defaultTTL},
+		{name: "entry", entryTTL: 60, qname: "foo.com.", qtype: dns.TypeA, want: 60},
+		{name: "type", typeTTLs: map[uint16]uint32{dns.TypeA: 30}, qname: "foo.com.", qtype: dns.TypeA, want: 30},
+		{name: "other type", typeTTLs: map[uint16]uint32{dns.TypeAAAA: 30}, qname: "foo.com.", qtype: dns.TypeA, want: defaultTTL},
+		{name: "shorter entry", entryTTL: 10, typeTTLs: map[uint16]uint32{dns.TypeA: 30}, qname: "foo.com.", qtype: dns.TypeA, want: 10},
+		{name: "longer entry", entryTTL: 60, typeTTLs: map[uint16]uint32{dns.TypeA: 30}, qname: "foo.com.", qtype: dns.TypeA, want: 30},
+		{name: "reverse", typeTTLs: map[uint16]uint32{dns.TypePTR: 600}, qname: "1.0.0.10.in-addr.arpa.", qtype: dns.TypePTR, want: 600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1"), ttl: tt.entryTTL}},
+				map[string][]string{"1.0.0.10.in-addr.arpa.": {"foo.com."}})
+			h.typeTTLs = tt.typeTTLs
+			response := query(t, h, tt.qname, tt.qtype)
+			if len(response.Answer) != 1 || response.Answer[0].Header().Ttl != tt.want {
+				t.Errorf("answer = %v, want a TTL of %d", response.Answer, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		changeTTL uint32
+		entryTTL  uint32
+		changedAt time.Duration
+		want      uint32
+	}{
+		{name: "disabled", changedAt: time.Second, want: defaultTTL},
+		{name: "never changed", changeTTL: 5, want: defaultTTL},
+		{name: "recently changed", changeTTL: 5, changedAt: time.Second, want: 5},
+		{name: "changed long ago", changeTTL: 5, changedAt: time.Hour, want: defaultTTL},
+		{name: "entry shorter", changeTTL: 5, entryTTL: 2, changedAt: time.Second, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &dnsEntry{vips: ips("10.0.0.1"), ttl: tt.entryTTL}
+			if tt.changedAt > 0 {
+				entry.changedAt = time.Now().Add(-tt.changedAt)
+			}
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": entry}, nil)
+			h.changeTTL = tt.changeTTL
+			h.changeWindow = time.Minute
+			response := query(t, h, "foo.com.", dns.TypeA)
+			if len(response.Answer) != 1 || response.Answer[0].Header().Ttl != tt.want {
+				t.Errorf("answer = %v, want a TTL of %d", response.Answer, tt.want)
+			}
+		})
+	}
+}
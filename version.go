@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// version is the build version, set with -ldflags "-X main.version=...".
+var version = "unknown"
+
+// versionTXT answers a TXT query for the configured version name with the
+// build version and the serial of the table. It reports false for other
+// queries.
+func (h *IstioServiceEntries) versionTXT(q dns.Question) ([]dns.RR, bool) {
+	if h.versionName == "" || q.Qtype != dns.TypeTXT || strings.ToLower(q.Name) != h.versionName {
+		return nil, false
+	}
+	h.mapMutex.RLock()
+	serial := h.serial
+	h.mapMutex.RUnlock()
+	return []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+		Txt: []string{"version=" + version, fmt.Sprintf("serial=%d", serial)},
+	}}, true
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestVersionTXT(t *testing.T) {
+	tests := []struct {
+		name        string
+		versionName string
+		qname       string
+		qtype       uint16
+		rcode       int
+		want        []string
+	}{
+		{name: "version", versionName: "version.coredns.local.", qname: "version.coredns.local.", qtype: dns.TypeTXT, want: []string{"version=unknown", "serial=7"}},
+		{name: "case", versionName: "version.coredns.local.", qname: "Version.CoreDNS.local.", qtype: dns.TypeTXT, want: []string{"version=unknown", "serial=7"}},
+		{name: "other type", versionName: "version.coredns.local.", qname: "version.coredns.local.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{name: "disabled", qname: "version.coredns.local.", qtype: dns.TypeTXT, rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.versionName = tt.versionName
+			h.serial = 7
+			response := query(t, h, tt.qname, tt.qtype)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
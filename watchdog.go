@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// watch re-reads the service entries every interval until stop is closed. If
+// reading panics, the panic is logged and watching starts over.
+func (h *IstioServiceEntries) watch(vip string, interval time.Duration, stop <-chan bool) {
+	for !h.runWatch(vip, interval, stop) {
+		log.Println("Restarting service entry watcher")
+	}
+}
+
+// runWatch is one incarnation of the watcher. It returns true once stop is
+// closed and false after recovering from a panic.
+func (h *IstioServiceEntries) runWatch(vip string, interval time.Duration, stop <-chan bool) (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Service entry watcher panicked: %v\n%s", r, debug.Stack())
+			stopped = false
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return true
+		case <-ticker.C:
+			h.readServiceEntries(vip)
+			h.heartbeat()
+		}
+	}
+}
+
+// heartbeat records that the watcher completed a read.
+func (h *IstioServiceEntries) heartbeat() {
+	atomic.StoreInt64(&h.lastHeartbeat, time.Now().UnixNano())
+}
+
+// alive reports whether the watcher completed a read within the watchdog
+// timeout. It always holds if no timeout is configured.
+func (h *IstioServiceEntries) alive() bool {
+	if h.watchdogTimeout <= 0 {
+		return true
+	}
+	last := time.Unix(0, atomic.LoadInt64(&h.lastHeartbeat))
+	return time.Since(last) <= h.watchdogTimeout
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		age     time.Duration
+		want    int
+	}{
+		{name: "no watchdog", age: time.Hour, want: http.StatusOK},
+		{name: "recent read", timeout: time.Minute, age: time.Second, want: http.StatusOK},
+		{name: "stalled", timeout: time.Minute, age: time.Hour, want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &IstioServiceEntries{watchdogTimeout: tt.timeout}
+			atomic.StoreInt64(&h.lastHeartbeat, time.Now().Add(-tt.age).UnixNano())
+			w := httptest.NewRecorder()
+			h.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunWatchRecovers(t *testing.T) {
+	// without a config store, reading panics
+	h := &IstioServiceEntries{}
+	done := make(chan bool)
+	go func() {
+		done <- h.runWatch("", time.Millisecond, make(chan bool))
+	}()
+	select {
+	case stopped := <-done:
+		if stopped {
+			t.Error("runWatch() reported a stop, want a recovered panic")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runWatch() did not return")
+	}
+
+	stop := make(chan bool)
+	close(stop)
+	if !h.runWatch("", time.Hour, stop) {
+		t.Error("runWatch() did not report the stop")
+	}
+}
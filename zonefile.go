@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// SOA timers of exported zones, in seconds.
+const (
+	soaRefresh = 3600
+	soaRetry   = 600
+	soaExpire  = 86400
+	soaMinimum = 30
+)
+
+// writeZone renders the part of the DNS table under origin as an RFC 1035
+// zone file, headed by an SOA carrying the table serial and an NS record for
+// the configured nameserver.
+func (h *IstioServiceEntries) writeZone(w io.Writer, origin string) error {
+	origin = strings.ToLower(dns.Fqdn(origin))
+	soa, records := h.zoneRecords(origin)
+	if _, err := fmt.Fprintf(w, "$ORIGIN %s\n%s\n", origin, soa); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if _, err := fmt.Fprintln(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zoneRecords returns the SOA of the zone at origin, which must be a lower
+// case FQDN, and its other records sorted by name, starting with the NS
+// record of the configured nameserver.
+func (h *IstioServiceEntries) zoneRecords(origin string) (*dns.SOA, []dns.RR) {
+	h.mapMutex.RLock()
+	table, ptrTable, serial := h.dnsEntries, h.ptrEntries, h.serial
+	h.mapMutex.RUnlock()
+
+	ns := h.nameserverName(origin)
+	soa := h.soa(origin, serial)
+	nsRecord := &dns.NS{
+		Hdr: dns.RR_Header{Name: origin, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: defaultTTL},
+		Ns:  ns,
+	}
+
+	records := make([]dns.RR, 0)
+	for key, entry := range table {
+		name := key
+		if strings.HasPrefix(key, ".") {
+			name = "*" + key
+		}
+		if !dns.IsSubDomain(origin, name) {
+			continue
+		}
+		if entry.cname != "" {
+			records = append(records, cname(name, entry.cname, entry.baseTTL())...)
+		} else {
+			records = append(records, addressRecords(name, dns.TypeANY, entry.vips, entry.baseTTL())...)
+		}
+	}
+	for name, hosts := range ptrTable {
+		if dns.IsSubDomain(origin, name) {
+			records = append(records, ptr(name, hosts, defaultTTL)...)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Header().Name < records[j].Header().Name
+	})
+	return soa, append([]dns.RR{nsRecord}, records...)
+}
+
+// soa returns the SOA record of the zone at origin.
+func (h *IstioServiceEntries) soa(origin string, serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: defaultTTL},
+		Ns:      h.nameserverName(origin),
+		Mbox:    dns.Fqdn("hostmaster." + strings.TrimPrefix(origin, ".")),
+		Serial:  serial,
+		Refresh: soaRefresh,
+		Retry:   soaRetry,
+		Expire:  soaExpire,
+		Minttl:  soaMinimum,
+	}
+}
+
+// negativeSOA returns the SOA record for the authority section of a negative
+// answer for name, owned by the apex of its zone, which resolvers cache the
+// answer for ttl seconds by (RFC 2308).
+func (h *IstioServiceEntries) negativeSOA(name string, ttl uint32) *dns.SOA {
+	h.mapMutex.RLock()
+	serial := h.serial
+	h.mapMutex.RUnlock()
+	soa := h.soa(zoneOf(h.zones, strings.ToLower(dns.Fqdn(name))), serial)
+	soa.Hdr.Ttl = ttl
+	soa.Minttl = ttl
+	return soa
+}
+
+// transfer answers a zone transfer request for the zone at name with the
+// whole zone in one message: the SOA, the records, and the SOA again. The
+// full zone is also a valid answer to IXFR (RFC 1995).
+func (h *IstioServiceEntries) transfer(name string) []dns.RR {
+	soa, records := h.zoneRecords(strings.ToLower(name))
+	answer := make([]dns.RR, 0, len(records)+2)
+	answer = append(answer, soa)
+	answer = append(answer, records...)
+	return append(answer, soa)
+}
+
+// nameserverName returns the name of the nameserver of a zone.
+func (h *IstioServiceEntries) nameserverName(origin string) string {
+	if h.nameserver != "" {
+		return dns.Fqdn(h.nameserver)
+	}
+	if origin == "." {
+		return "ns."
+	}
+	return "ns." + origin
+}
+
+// nameserverAnswer answers queries for the configured --nameserver name with
+// the --nameserver-address addresses of the queried family, and without
+// records if there are none or the query is for another type. It reports
+// false for other names, and if no nameserver name is configured.
+func (h *IstioServiceEntries) nameserverAnswer(q dns.Question) ([]dns.RR, bool) {
+	if h.nameserver == "" || !strings.EqualFold(q.Name, dns.Fqdn(h.nameserver)) {
+		return nil, false
+	}
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeANY:
+		return addressRecords(q.Name, q.Qtype, h.nameserverIPs, h.typeTTL(q.Qtype)), true
+	}
+	return nil, true
+}
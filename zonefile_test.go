@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestWriteZone(t *testing.T) {
+	h := newTestHandle(map[string]*dnsEntry{
+		"foo.example.com.": {vips: ips("10.0.0.1", "2001:db8::1"), ttl: 60},
+		"bar.example.com.": {cname: "foo.example.com."},
+		".example.com.":    {vips: ips("10.0.0.2")},
+		"other.org.":       {vips: ips("10.0.0.3")},
+	}, map[string][]string{"1.0.0.10.in-addr.arpa.": {"foo.example.com."}})
+	h.serial = 42
+	tests := []struct {
+		name   string
+		origin string
+		want   []string
+	}{
+		{
+			name:   "zone",
+			origin: "Example.com",
+			want: []string{
+				"$ORIGIN example.com.",
+				"example.com.\t3600\tIN\tSOA\tns.example.com. hostmaster.example.com. 42 3600 600 86400 30",
+				"example.com.\t3600\tIN\tNS\tns.example.com.",
+				"*.example.com.\t3600\tIN\tA\t10.0.0.2",
+				"bar.example.com.\t3600\tIN\tCNAME\tfoo.example.com.",
+				"foo.example.com.\t60\tIN\tA\t10.0.0.1",
+				"foo.example.com.\t60\tIN\tAAAA\t2001:db8::1",
+			},
+		},
+		{
+			name:   "reverse",
+			origin: "in-addr.arpa.",
+			want: []string{
+				"$ORIGIN in-addr.arpa.",
+				"in-addr.arpa.\t3600\tIN\tSOA\tns.in-addr.arpa. hostmaster.in-addr.arpa. 42 3600 600 86400 30",
+				"in-addr.arpa.\t3600\tIN\tNS\tns.in-addr.arpa.",
+				"1.0.0.10.in-addr.arpa.\t3600\tIN\tPTR\tfoo.example.com.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := h.writeZone(&b, tt.origin); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Split(strings.TrimSpace(b.String()), "\n")
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("zone:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestZoneTransfer(t *testing.T) {
+	tests := []struct {
+		name          string
+		qtype         uint16
+		allowTransfer bool
+		rcode         int
+		want          []string
+	}{
+		{name: "refused", qtype: dns.TypeAXFR, rcode: dns.RcodeRefused},
+		{name: "incremental refused", qtype: dns.TypeIXFR, rcode: dns.RcodeRefused},
+		{
+			name:          "transferred",
+			qtype:         dns.TypeAXFR,
+			allowTransfer: true,
+			want:          []string{"SOA", "NS", "A", "SOA"},
+		},
+		{
+			name:          "incremental as full",
+			qtype:         dns.TypeIXFR,
+			allowTransfer: true,
+			want:          []string{"SOA", "NS", "A", "SOA"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{
+				"foo.example.com.": {vips: ips("10.0.0.1")},
+				"other.org.":       {vips: ips("10.0.0.2")},
+			}, nil)
+			h.allowTransfer = tt.allowTransfer
+			response := query(t, h, "example.com.", tt.qtype)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			var got []string
+			for _, rr := range response.Answer {
+				got = append(got, dns.TypeToString[rr.Header().Rrtype])
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("answer types = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameserverAnswer(t *testing.T) {
+	tests := []struct {
+		name       string
+		nameserver string
+		qname      string
+		qtype      uint16
+		rcode      int
+		want       []string
+	}{
+		{name: "A", nameserver: "ns.example.com", qname: "ns.example.com.", qtype: dns.TypeA, want: []string{"192.0.2.53"}},
+		{name: "AAAA", nameserver: "ns.example.com.", qname: "NS.example.com.", qtype: dns.TypeAAAA, want: []string{"2001:db8::53"}},
+		{name: "ANY", nameserver: "ns.example.com", qname: "ns.example.com.", qtype: dns.TypeANY, want: []string{"192.0.2.53", "2001:db8::53"}},
+		{name: "other type", nameserver: "ns.example.com", qname: "ns.example.com.", qtype: dns.TypeTXT},
+		{name: "over the table", nameserver: "foo.example.com", qname: "foo.example.com.", qtype: dns.TypeA, want: []string{"192.0.2.53"}},
+		{name: "unconfigured", qname: "ns.example.com.", qtype: dns.TypeA, rcode: dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandle(map[string]*dnsEntry{"foo.example.com.": {vips: ips("10.0.0.1")}}, nil)
+			h.nameserver = tt.nameserver
+			h.nameserverIPs = ips("192.0.2.53", "2001:db8::53")
+			response := query(t, h, tt.qname, tt.qtype)
+			if response.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[response.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := rdata(response.Answer); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
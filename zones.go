@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// reverseZones hold the reverse names of the PTR records.
+var reverseZones = []string{"in-addr.arpa.", "ip6.arpa."}
+
+// parseZones parses the comma separated zones of --zones into lower case
+// FQDNs, adding the reverse zones.
+func parseZones(value string) []string {
+	zones := append([]string{}, reverseZones...)
+	for _, zone := range strings.Split(value, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, strings.ToLower(dns.Fqdn(zone)))
+		}
+	}
+	return zones
+}
+
+// zoneOf returns the apex of the zone of name, a lower case FQDN: the
+// longest of zones containing it, or the name of its last two labels if
+// none does.
+func zoneOf(zones []string, name string) string {
+	apex := ""
+	for _, zone := range zones {
+		if len(zone) > len(apex) && dns.IsSubDomain(zone, name) {
+			apex = zone
+		}
+	}
+	if apex != "" {
+		return apex
+	}
+	labels := dns.Split(name)
+	if len(labels) <= 2 {
+		return name
+	}
+	return name[labels[len(labels)-2]:]
+}
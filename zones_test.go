@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseZones(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{value: "", want: reverseZones},
+		{value: "Svc.Cluster.Local, example.com.,", want: append(append([]string{}, reverseZones...), "svc.cluster.local.", "example.com.")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseZones(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseZones() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneOf(t *testing.T) {
+	zones := parseZones("example.com,svc.cluster.local,ns.svc.cluster.local")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "foo.example.com.", want: "example.com."},
+		{name: "example.com.", want: "example.com."},
+		{name: "a.b.svc.cluster.local.", want: "svc.cluster.local."},
+		{name: "a.ns.svc.cluster.local.", want: "ns.svc.cluster.local."},
+		{name: "1.0.0.10.in-addr.arpa.", want: "in-addr.arpa."},
+		{name: "a.b.other.org.", want: "other.org."},
+		{name: "org.", want: "org."},
+		{name: ".", want: "."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zoneOf(zones, tt.name); got != tt.want {
+				t.Errorf("zoneOf(%s) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}